@@ -0,0 +1,279 @@
+package main
+
+import (
+	"Totem/catalog"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Posts by weekday (time.Weekday) & hour of the day
+type heatmap [7][24]int
+
+// Rows are printed Monday first
+var heatmapWeekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+// The fewest posts needed to estimate a UTC offset
+const minPostsForOffset = 24
+
+// The hours people are assumed to sleep, 1h to 7h local time, which have the fewest posts
+const sleepStart, sleepHours = 1, 6
+
+func buildHeatmap(times []time.Time, loc *time.Location) heatmap {
+	var h heatmap
+	for _, t := range times {
+		t = t.In(loc)
+		h[t.Weekday()][t.Hour()]++
+	}
+	return h
+}
+
+func (h heatmap) max() int {
+	max := 0
+	for _, day := range h {
+		for _, n := range day {
+			if n > max {
+				max = n
+			}
+		}
+	}
+	return max
+}
+
+// Estimates the UTC offset of whoever made the posts at times, assuming the sleepHours in a row with
+// the fewest posts start at sleepStart local time. Returns false if there are too few posts to tell.
+func inferUTCOffset(times []time.Time) (time.Duration, bool) {
+	if len(times) < minPostsForOffset {
+		return 0, false
+	}
+
+	var hours [24]int
+	for _, t := range times {
+		hours[t.UTC().Hour()]++
+	}
+
+	// Ties go to the window with the fewest posts in the hours either side of it,
+	// so a quiet stretch longer than sleepHours is split evenly
+	trough, fewest, fewestAround := 0, len(times)+1, len(times)+1
+	for start := 0; start < 24; start++ {
+		n := 0
+		for k := 0; k < sleepHours; k++ {
+			n += hours[(start+k)%24]
+		}
+		around := hours[(start+23)%24] + hours[(start+sleepHours)%24]
+		if n < fewest || (n == fewest && around < fewestAround) {
+			trough, fewest, fewestAround = start, n, around
+		}
+	}
+
+	// Between UTC-11 & UTC+12
+	offset := ((sleepStart-trough)%24 + 24) % 24
+	if offset > 12 {
+		offset -= 24
+	}
+	return time.Duration(offset) * time.Hour, true
+}
+
+// Parses a UTC offset like +02:00, -5 or +5:30
+func parseUTCOffset(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	unsigned := s
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = -1
+		unsigned = s[1:]
+	case strings.HasPrefix(s, "+"):
+		unsigned = s[1:]
+	}
+
+	parts := strings.SplitN(unsigned, ":", 2)
+	hours, err := strconv.Atoi(parts[0])
+	minutes := 0
+	if err == nil && len(parts) == 2 {
+		minutes, err = strconv.Atoi(parts[1])
+	}
+	if err != nil || hours > 14 || minutes >= 60 {
+		return 0, fmt.Errorf("invalid UTC offset %q, use e.g. +02:00", s)
+	}
+	return sign * (time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute), nil
+}
+
+// The UTC offset to read a target's posts in: offsetFlag if it is set, otherwise the one inferred from when
+// they posted, at times, otherwise UTC. Prints which it is.
+func targetOffset(times []time.Time, offsetFlag string) (time.Duration, error) {
+	if offsetFlag != "" {
+		offset, err := parseUTCOffset(offsetFlag)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Println("Times in", formatUTCOffset(offset))
+		return offset, nil
+	}
+
+	offset, inferred := inferUTCOffset(times)
+	if inferred {
+		fmt.Println("Likely", formatUTCOffset(offset)+", from the hours with the fewest posts. Times in", formatUTCOffset(offset))
+	} else {
+		fmt.Println("Too few posts to estimate a UTC offset, at least", minPostsForOffset, "are needed. Times in UTC")
+	}
+	return offset, nil
+}
+
+func formatUTCOffset(offset time.Duration) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("UTC%s%02d:%02d", sign, int(offset.Hours()), int(offset.Minutes())%60)
+}
+
+// How long after each post was captured it was uploaded, for posts with a capture time before their upload
+func captureGaps(posts []catalog.Media) []time.Duration {
+	var gaps []time.Duration
+	for _, m := range posts {
+		if m.Captured != 0 && m.Captured <= m.Uploaded {
+			gaps = append(gaps, time.Duration(m.Uploaded-m.Captured)*time.Millisecond)
+		}
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	return gaps
+}
+
+// Prints h as a grid of shades, darker for more posts
+func printHeatmap(w io.Writer, h heatmap) {
+	shades := []rune(" ░▒▓█")
+	max := h.max()
+
+	fmt.Fprint(w, "     ")
+	for hour := 0; hour < 24; hour += 3 {
+		fmt.Fprintf(w, "%-6d", hour)
+	}
+	fmt.Fprintln(w)
+
+	for _, day := range heatmapWeekdays {
+		row := make([]rune, 0, 48)
+		for hour := 0; hour < 24; hour++ {
+			shade := shades[0]
+			if n := h[day][hour]; n > 0 {
+				shade = shades[(n*(len(shades)-1)+max-1)/max]
+			}
+			row = append(row, shade, shade)
+		}
+		fmt.Fprintln(w, day.String()[:3], "|"+string(row)+"|")
+	}
+}
+
+const heatmapCell, heatmapMargin = 24, 40
+
+// The colour of a cell with n posts, from white to dark red
+func heatmapColor(n, max int) color.RGBA {
+	if max == 0 {
+		max = 1
+	}
+	f := float64(n) / float64(max)
+	return color.RGBA{uint8(255 - 115*f), uint8(255 - 255*f), uint8(255 - 255*f), 255}
+}
+
+func writeHeatmapPNG(file string, h heatmap) error {
+	img := image.NewRGBA(image.Rect(0, 0, 24*heatmapCell, 7*heatmapCell))
+	max := h.max()
+	for row, day := range heatmapWeekdays {
+		for hour := 0; hour < 24; hour++ {
+			c := heatmapColor(h[day][hour], max)
+			for y := row * heatmapCell; y < (row+1)*heatmapCell-1; y++ {
+				for x := hour * heatmapCell; x < (hour+1)*heatmapCell-1; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, img)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeHeatmapSVG(file string, h heatmap, title string) error {
+	var b strings.Builder
+	width, height := heatmapMargin+24*heatmapCell, heatmapMargin+7*heatmapCell
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n", width, height)
+	fmt.Fprintf(&b, `<title>%s</title>`+"\n", html.EscapeString(title))
+
+	for hour := 0; hour < 24; hour += 3 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%d</text>`+"\n", heatmapMargin+hour*heatmapCell, heatmapMargin-8, hour)
+	}
+
+	max := h.max()
+	for row, day := range heatmapWeekdays {
+		y := heatmapMargin + row*heatmapCell
+		fmt.Fprintf(&b, `<text x="4" y="%d">%s</text>`+"\n", y+heatmapCell*2/3, day.String()[:3])
+		for hour := 0; hour < 24; hour++ {
+			c := heatmapColor(h[day][hour], max)
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#%02x%02x%02x"><title>%s %02dh: %d posts</title></rect>`+"\n",
+				heatmapMargin+hour*heatmapCell, y, heatmapCell-1, heatmapCell-1, c.R, c.G, c.B, day, hour, h[day][hour])
+		}
+	}
+	b.WriteString("</svg>\n")
+
+	return os.WriteFile(file, []byte(b.String()), os.ModePerm)
+}
+
+// Prints when the target posts to their galleries as a heatmap in their UTC offset, inferred unless offsetFlag is set,
+// along with how long after capturing posts they upload them. The heatmap is also written to pngFile & svgFile if set.
+func printActivity(targetName, offsetFlag, pngFile, svgFile string) error {
+	media, err := catalogDB.SearchMedia(catalog.Query{Target: targetName})
+	if err != nil {
+		return err
+	}
+
+	var posts []catalog.Media
+	var times []time.Time
+	for _, m := range media {
+		if m.Collected == 0 && m.Uploaded != 0 {
+			posts = append(posts, m)
+			times = append(times, m.Time())
+		}
+	}
+	fmt.Println(len(posts), "posts by", targetName)
+
+	offset, err := targetOffset(times, offsetFlag)
+	if err != nil {
+		return err
+	}
+
+	h := buildHeatmap(times, time.FixedZone(formatUTCOffset(offset), int(offset.Seconds())))
+	printHeatmap(os.Stdout, h)
+
+	if gaps := captureGaps(posts); len(gaps) > 0 {
+		fmt.Println("Uploaded after capture: median", gaps[len(gaps)/2].Round(time.Minute), "| shortest", gaps[0].Round(time.Minute), "| longest", gaps[len(gaps)-1].Round(time.Minute), "| from", len(gaps), "posts")
+	}
+
+	title := targetName + " posts by weekday & hour, " + formatUTCOffset(offset)
+	if pngFile != "" {
+		if err := writeHeatmapPNG(pngFile, h); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", pngFile)
+	}
+	if svgFile != "" {
+		if err := writeHeatmapSVG(svgFile, h, title); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", svgFile)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Package blobstore keeps files by the SHA-256 of their contents,
+// so identical media stored by several accounts & targets takes up space once.
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// A content-addressed store of files.
+// Files are hard linked to their blob, or copied if they cannot be, e.g. when they are on another filesystem.
+type Store struct {
+	dir string
+}
+
+// A Store keeping its blobs under dir, which is created when the first blob is stored.
+func New(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// The hex SHA-256 of data, which names its blob
+func Sum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// The path of the blob named sum, whether or not it is stored
+func (s *Store) Path(sum string) string {
+	return s.dir + "/" + sum[:2] + "/" + sum
+}
+
+// Stores data if it is not stored yet & links path to its blob.
+// Returns the sum naming the blob.
+func (s *Store) Put(path string, data []byte) (string, error) {
+	sum := Sum(data)
+	blob := s.Path(sum)
+
+	if _, err := os.Stat(blob); errors.Is(err, os.ErrNotExist) {
+		if err := writeBlob(blob, data); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	return sum, link(blob, path)
+}
+
+// Moves the file at path into the store, replacing it with a link to its blob if the blob was already stored.
+// Returns the sum naming the blob.
+func (s *Store) Adopt(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := Sum(data)
+	blob := s.Path(sum)
+
+	blobInfo, err := os.Stat(blob)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(blob), os.ModePerm); err != nil {
+			return "", err
+		}
+		if os.Link(path, blob) == nil {
+			return sum, nil
+		}
+		return sum, writeBlob(blob, data)
+	} else if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if os.SameFile(info, blobInfo) {
+		return sum, nil
+	}
+
+	// Link next to path first, so path is never missing
+	tmp := path + ".blob"
+	os.Remove(tmp)
+	if err := os.Link(blob, tmp); err != nil {
+		// Keep the copy, it cannot share the blob
+		return sum, nil
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return sum, nil
+}
+
+// Writes data to blob through a temporary file, so a blob is never partly written
+func writeBlob(blob string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(blob), os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(blob), filepath.Base(blob)+".*")
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(os.ModePerm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), blob)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Hard links path to blob, or copies blob to path if it cannot be linked
+func link(blob, path string) error {
+	if os.Link(blob, path) == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(blob)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, os.ModePerm)
+}
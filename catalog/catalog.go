@@ -0,0 +1,224 @@
+// Package catalog is an index of everything Totem has archived, kept in a single embedded database.
+// Services keep it in sync as they store data, & it can be rebuilt from what is stored on disk.
+// The files on disk stay the source of truth, the catalog only makes them quick to query.
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	targetsBucket       = []byte("targets")
+	accountsBucket      = []byte("accounts")
+	sitesBucket         = []byte("sites")
+	mediaBucket         = []byte("media")
+	biosBucket          = []byte("bios")
+	profileImagesBucket = []byte("profile_images")
+	eventsBucket        = []byte("events")
+
+	// Every bucket that holds records of an account, keyed by AccountRef.key
+	accountBuckets = [][]byte{accountsBucket, sitesBucket, mediaBucket, biosBucket, profileImagesBucket, eventsBucket}
+)
+
+// The catalog is only locked while a transaction uses it, so a long run that writes now & then
+// does not keep other totems from reading it in between.
+type DB struct {
+	path     string
+	readOnly bool
+}
+
+// How long a transaction waits for another totem to be done with the catalog
+const (
+	writeTimeout = 30 * time.Second
+	readTimeout  = 10 * time.Second
+)
+
+// Opens the catalog at path for reading & writing, creating it if it does not exist
+func Open(path string) (*DB, error) {
+	db := &DB{path: path}
+	err := db.update("create buckets", func(tx *bolt.Tx) error {
+		for _, name := range append([][]byte{targetsBucket}, accountBuckets...) {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Opens the catalog at path for reading only, alongside other totems reading it.
+// Fails if there is no catalog yet.
+func OpenReadOnly(path string) (*DB, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("catalog: %s does not exist yet, it is written by totem run & totem reindex", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("catalog: open %s: %w", path, err)
+	}
+	return &DB{path: path, readOnly: true}, nil
+}
+
+// Nothing is held open between transactions, Close is only there to mark when the catalog is no longer used
+func (db *DB) Close() error {
+	return nil
+}
+
+// Locks & opens the catalog for a single transaction
+func (db *DB) open() (*bolt.DB, error) {
+	timeout := writeTimeout
+	if db.readOnly {
+		timeout = readTimeout
+	}
+	b, err := bolt.Open(db.path, 0666, &bolt.Options{Timeout: timeout, ReadOnly: db.readOnly})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is in use by another totem", db.path)
+	} else if err != nil {
+		return nil, fmt.Errorf("open %s: %w", db.path, err)
+	}
+	return b, nil
+}
+
+// Identifies one of a target's accounts
+type AccountRef struct {
+	Target   string `json:"target"`
+	Platform string `json:"platform"`
+	Username string `json:"username"`
+}
+
+// Keys of a target's records start with the target's name, then the account's platform & username
+func targetKey(target string) []byte {
+	return []byte(target + "\x00")
+}
+
+func (a AccountRef) key() []byte {
+	return []byte(a.Target + "\x00" + a.Platform + "\x00" + a.Username + "\x00")
+}
+
+func join(prefix []byte, parts ...string) []byte {
+	key := append([]byte(nil), prefix...)
+	for i, p := range parts {
+		if i > 0 {
+			key = append(key, 0)
+		}
+		key = append(key, p...)
+	}
+	return key
+}
+
+// A big-endian time followed by a sequence number, so records are kept in order
+func timeKey(prefix []byte, t int64, seq uint64) []byte {
+	key := make([]byte, len(prefix)+16)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(t))
+	binary.BigEndian.PutUint64(key[len(prefix)+8:], seq)
+	return key
+}
+
+func put(b *bolt.Bucket, key []byte, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, value)
+}
+
+// Deletes every record in b whose key starts with prefix
+func deletePrefix(b *bolt.Bucket, prefix []byte) error {
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls f with every record in bucket whose key starts with prefix, in key order
+func each(tx *bolt.Tx, bucket []byte, prefix []byte, f func(value []byte) error) error {
+	c := tx.Bucket(bucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := f(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) update(op string, f func(tx *bolt.Tx) error) error {
+	if db.readOnly {
+		return fmt.Errorf("catalog: %s: opened read-only", op)
+	}
+	b, err := db.open()
+	if err == nil {
+		err = b.Update(f)
+		if closeErr := b.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("catalog: %s: %w", op, err)
+	}
+	return nil
+}
+
+func (db *DB) view(op string, f func(tx *bolt.Tx) error) error {
+	b, err := db.open()
+	if err == nil {
+		err = b.View(f)
+		b.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("catalog: %s: %w", op, err)
+	}
+	return nil
+}
+
+// Removes everything from the catalog
+func (db *DB) Clear() error {
+	return db.update("clear", func(tx *bolt.Tx) error {
+		for _, name := range append([][]byte{targetsBucket}, accountBuckets...) {
+			if err := deletePrefix(tx.Bucket(name), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Removes the target & all of its accounts' records
+func (db *DB) RemoveTarget(target string) error {
+	return db.update("remove target "+target, func(tx *bolt.Tx) error {
+		if err := tx.Bucket(targetsBucket).Delete([]byte(target)); err != nil {
+			return err
+		}
+		for _, name := range accountBuckets {
+			if err := deletePrefix(tx.Bucket(name), targetKey(target)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Removes the account & all of its records
+func (db *DB) RemoveAccount(ref AccountRef) error {
+	return db.update("remove account "+ref.Username, func(tx *bolt.Tx) error {
+		for _, name := range accountBuckets {
+			if err := deletePrefix(tx.Bucket(name), ref.key()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,325 @@
+package catalog
+
+import (
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Times are Unix seconds unless noted otherwise.
+
+type Target struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+type Account struct {
+	AccountRef
+	IDs     map[string]string `json:"ids,omitempty"`
+	Status  string            `json:"status,omitempty"`
+	Created int64             `json:"created,omitempty"`
+}
+
+// The account's profile on its platform, as last seen
+type Site struct {
+	AccountRef
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	ProfileImageID  string `json:"profile_image_id,omitempty"`
+	ProfileImageURL string `json:"profile_image_url,omitempty"`
+	Seen            int64  `json:"seen"`
+}
+
+type Media struct {
+	AccountRef
+
+	// The media source the media is stored in, e.g. "Gallery"
+	Source string `json:"source"`
+	ID     string `json:"id"`
+
+	// The directory the media is stored in & its image or video
+	Dir  string `json:"dir"`
+	File string `json:"file,omitempty"`
+
+	// Hex SHA-256 of File
+	SHA256 string `json:"sha256,omitempty"`
+
+	Description string `json:"description,omitempty"`
+	Tags        []Tag  `json:"tags,omitempty"`
+	IsVideo     bool   `json:"is_video,omitempty"`
+
+	// When the media was captured, uploaded, added to the collection & last edited, in milliseconds, 0 if unknown
+	Captured  int64 `json:"captured,omitempty"`
+	Uploaded  int64 `json:"uploaded,omitempty"`
+	Collected int64 `json:"collected,omitempty"`
+	Updated   int64 `json:"updated,omitempty"`
+
+	// When the media was noticed to be gone from the platform, 0 if it is not
+	Deleted int64 `json:"deleted,omitempty"`
+
+	HasLocation bool    `json:"has_location,omitempty"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+
+	// The camera the media was taken with
+	Make  string `json:"make,omitempty"`
+	Model string `json:"model,omitempty"`
+
+	// What the image or video's own metadata says, nil if it has not been read
+	FileMetadata *FileMetadata `json:"file_metadata,omitempty"`
+
+	// Where the file's metadata disagrees with the platform's
+	Discrepancies []string `json:"discrepancies,omitempty"`
+}
+
+// Read from a media's EXIF, XMP or MP4 metadata. Empty fields were not in the file.
+type FileMetadata struct {
+	Make     string `json:"make,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Software string `json:"software,omitempty"`
+
+	// In milliseconds
+	Captured int64 `json:"captured,omitempty"`
+
+	HasLocation bool    `json:"has_location,omitempty"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+}
+
+type Tag struct {
+	Text string `json:"text"`
+	Slug string `json:"slug"`
+}
+
+type Bio struct {
+	AccountRef
+	Description string `json:"description"`
+	Recorded    int64  `json:"recorded"`
+}
+
+type ProfileImage struct {
+	AccountRef
+	ImageID   string `json:"image_id"`
+	File      string `json:"file,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+}
+
+// Something that changed on an account, see service.Event
+type Event struct {
+	AccountRef
+	Kind        string   `json:"kind"`
+	Time        int64    `json:"time"`
+	Source      string   `json:"source,omitempty"`
+	MediaID     string   `json:"media_id,omitempty"`
+	Changes     []string `json:"changes,omitempty"`
+	Description string   `json:"description"`
+}
+
+// Records of all targets if target is empty
+func targetPrefix(target string) []byte {
+	if target == "" {
+		return nil
+	}
+	return targetKey(target)
+}
+
+func (db *DB) PutTarget(t Target) error {
+	return db.update("put target "+t.Name, func(tx *bolt.Tx) error {
+		return put(tx.Bucket(targetsBucket), []byte(t.Name), t)
+	})
+}
+
+func (db *DB) Targets() ([]Target, error) {
+	var targets []Target
+	err := db.view("read targets", func(tx *bolt.Tx) error {
+		return each(tx, targetsBucket, nil, func(value []byte) error {
+			var t Target
+			if err := json.Unmarshal(value, &t); err != nil {
+				return err
+			}
+			targets = append(targets, t)
+			return nil
+		})
+	})
+	return targets, err
+}
+
+func (db *DB) PutAccount(a Account) error {
+	return db.update("put account "+a.Username, func(tx *bolt.Tx) error {
+		return put(tx.Bucket(accountsBucket), a.key(), a)
+	})
+}
+
+// The accounts of target, or of every target if it is empty
+func (db *DB) Accounts(target string) ([]Account, error) {
+	var accounts []Account
+	err := db.view("read accounts", func(tx *bolt.Tx) error {
+		return each(tx, accountsBucket, targetPrefix(target), func(value []byte) error {
+			var a Account
+			if err := json.Unmarshal(value, &a); err != nil {
+				return err
+			}
+			accounts = append(accounts, a)
+			return nil
+		})
+	})
+	return accounts, err
+}
+
+func (db *DB) PutSite(s Site) error {
+	return db.update("put site "+s.Username, func(tx *bolt.Tx) error {
+		return put(tx.Bucket(sitesBucket), s.key(), s)
+	})
+}
+
+// The sites of target's accounts, or of every account if it is empty
+func (db *DB) Sites(target string) ([]Site, error) {
+	var sites []Site
+	err := db.view("read sites", func(tx *bolt.Tx) error {
+		return each(tx, sitesBucket, targetPrefix(target), func(value []byte) error {
+			var s Site
+			if err := json.Unmarshal(value, &s); err != nil {
+				return err
+			}
+			sites = append(sites, s)
+			return nil
+		})
+	})
+	return sites, err
+}
+
+// Adds media, replacing the records of media that is already in the catalog
+func (db *DB) PutMedia(media ...Media) error {
+	if len(media) == 0 {
+		return nil
+	}
+	return db.update("put media", func(tx *bolt.Tx) error {
+		b := tx.Bucket(mediaBucket)
+		for _, m := range media {
+			if err := put(b, join(m.key(), m.Source, m.ID), m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// The media of target's accounts, or of every account if it is empty.
+// Sorted by account, then by source & media ID.
+func (db *DB) Media(target string) ([]Media, error) {
+	var media []Media
+	err := db.view("read media", func(tx *bolt.Tx) error {
+		return each(tx, mediaBucket, targetPrefix(target), func(value []byte) error {
+			var m Media
+			if err := json.Unmarshal(value, &m); err != nil {
+				return err
+			}
+			media = append(media, m)
+			return nil
+		})
+	})
+	return media, err
+}
+
+// Replaces the account's bios with bios, which all have ref as their AccountRef
+func (db *DB) SetBios(ref AccountRef, bios []Bio) error {
+	return db.update("put bios of "+ref.Username, func(tx *bolt.Tx) error {
+		b := tx.Bucket(biosBucket)
+		if err := deletePrefix(b, ref.key()); err != nil {
+			return err
+		}
+		for i, bio := range bios {
+			if err := put(b, timeKey(ref.key(), bio.Recorded, uint64(i)), bio); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// The bios of target's accounts, or of every account if it is empty, oldest first for each account
+func (db *DB) Bios(target string) ([]Bio, error) {
+	var bios []Bio
+	err := db.view("read bios", func(tx *bolt.Tx) error {
+		return each(tx, biosBucket, targetPrefix(target), func(value []byte) error {
+			var b Bio
+			if err := json.Unmarshal(value, &b); err != nil {
+				return err
+			}
+			bios = append(bios, b)
+			return nil
+		})
+	})
+	return bios, err
+}
+
+// Replaces the account's profile images with images, which all have ref as their AccountRef
+func (db *DB) SetProfileImages(ref AccountRef, images []ProfileImage) error {
+	return db.update("put profile images of "+ref.Username, func(tx *bolt.Tx) error {
+		b := tx.Bucket(profileImagesBucket)
+		if err := deletePrefix(b, ref.key()); err != nil {
+			return err
+		}
+		for i, image := range images {
+			if err := put(b, timeKey(ref.key(), image.FirstSeen, uint64(i)), image); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// The profile images of target's accounts, or of every account if it is empty, oldest first for each account
+func (db *DB) ProfileImages(target string) ([]ProfileImage, error) {
+	var images []ProfileImage
+	err := db.view("read profile images", func(tx *bolt.Tx) error {
+		return each(tx, profileImagesBucket, targetPrefix(target), func(value []byte) error {
+			var p ProfileImage
+			if err := json.Unmarshal(value, &p); err != nil {
+				return err
+			}
+			images = append(images, p)
+			return nil
+		})
+	})
+	return images, err
+}
+
+// Adds events to the ones already in the catalog
+func (db *DB) AddEvents(events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return db.update("add events", func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+		for _, e := range events {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := put(b, timeKey(e.key(), e.Time, seq), e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// The events of target's accounts, or of every account if it is empty, oldest first for each account
+func (db *DB) Events(target string) ([]Event, error) {
+	var events []Event
+	err := db.view("read events", func(tx *bolt.Tx) error {
+		return each(tx, eventsBucket, targetPrefix(target), func(value []byte) error {
+			var e Event
+			if err := json.Unmarshal(value, &e); err != nil {
+				return err
+			}
+			events = append(events, e)
+			return nil
+		})
+	})
+	return events, err
+}
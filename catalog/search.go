@@ -0,0 +1,144 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Filters for SearchMedia & SearchBios. Empty filters match everything.
+// Text filters are case-insensitive.
+type Query struct {
+	Target  string
+	Account string
+
+	// Matched against when media appeared on the account & when bios were recorded, From & To included
+	From time.Time
+	To   time.Time
+
+	// Part of a caption or bio
+	Text string
+
+	// Media-only filters, bios are not searched if any of these are set
+	Tag           string // A tag's slug
+	Make          string // Part of the camera's make, on the platform or in the file
+	Model         string // Part of the camera's model, on the platform or in the file
+	HasLocation   *bool  // Whether the platform or the file has a location
+	MediaType     string // "image" or "video"
+	Discrepancies bool   // Only media whose file disagrees with the platform
+}
+
+// Set if the query can only match media
+func (q Query) mediaOnly() bool {
+	return q.Tag != "" || q.Make != "" || q.Model != "" || q.HasLocation != nil || q.MediaType != "" || q.Discrepancies
+}
+
+func (q Query) matchesAccount(a AccountRef) bool {
+	return (q.Target == "" || a.Target == q.Target) && (q.Account == "" || a.Username == q.Account)
+}
+
+func (q Query) matchesTime(t time.Time) bool {
+	return (q.From.IsZero() || !t.Before(q.From)) && (q.To.IsZero() || !t.After(q.To))
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// When the media appeared on the account: when it was collected for collected media, otherwise when it was uploaded
+func (m Media) Time() time.Time {
+	if m.Collected != 0 {
+		return time.Unix(0, m.Collected*int64(time.Millisecond))
+	}
+	return time.Unix(0, m.Uploaded*int64(time.Millisecond))
+}
+
+func (q Query) MatchesMedia(m Media) bool {
+	if !q.matchesAccount(m.AccountRef) || !q.matchesTime(m.Time()) {
+		return false
+	}
+	if q.Text != "" && !containsFold(m.Description, q.Text) {
+		return false
+	}
+	if q.Tag != "" {
+		tagged := false
+		for _, t := range m.Tags {
+			tagged = tagged || strings.EqualFold(t.Slug, q.Tag)
+		}
+		if !tagged {
+			return false
+		}
+	}
+	file := m.FileMetadata
+	if file == nil {
+		file = &FileMetadata{}
+	}
+	if q.Make != "" && !containsFold(m.Make, q.Make) && !containsFold(file.Make, q.Make) {
+		return false
+	}
+	if q.Model != "" && !containsFold(m.Model, q.Model) && !containsFold(file.Model, q.Model) {
+		return false
+	}
+	if q.HasLocation != nil && (m.HasLocation || file.HasLocation) != *q.HasLocation {
+		return false
+	}
+	if q.Discrepancies && len(m.Discrepancies) == 0 {
+		return false
+	}
+	switch q.MediaType {
+	case "image":
+		return !m.IsVideo
+	case "video":
+		return m.IsVideo
+	}
+	return true
+}
+
+func (q Query) MatchesBio(b Bio) bool {
+	if q.mediaOnly() || !q.matchesAccount(b.AccountRef) || !q.matchesTime(time.Unix(b.Recorded, 0)) {
+		return false
+	}
+	return q.Text == "" || containsFold(b.Description, q.Text)
+}
+
+// The media matching q, oldest first
+func (db *DB) SearchMedia(q Query) ([]Media, error) {
+	media, err := db.Media(q.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []Media
+	for _, m := range media {
+		if q.MatchesMedia(m) {
+			matches = append(matches, m)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Time().Before(matches[j].Time())
+	})
+	return matches, nil
+}
+
+// The bios matching q, oldest first
+func (db *DB) SearchBios(q Query) ([]Bio, error) {
+	if q.mediaOnly() {
+		return nil, nil
+	}
+
+	bios, err := db.Bios(q.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []Bio
+	for _, b := range bios {
+		if q.MatchesBio(b) {
+			matches = append(matches, b)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Recorded < matches[j].Recorded
+	})
+	return matches, nil
+}
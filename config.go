@@ -0,0 +1,100 @@
+package main
+
+import (
+	"Totem/blobstore"
+	"Totem/service"
+	"Totem/transport"
+	"errors"
+	"net/http"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Optional settings in Totem/Config.yaml:
+//
+//	baseurls:
+//	  vsco: https://mirror.example.com/api/2.0
+//	ratelimits:
+//	  vsco.co: {rate: 2, burst: 4}
+//	  "*": {rate: 5, burst: 5}
+//	retries: 4
+//	notify: [notify-send, Totem]
+type totemConfig struct {
+	// API base URL per platform, replaces the platform's default
+	BaseURLs map[string]string
+
+	// Requests per second allowed per host, "*" applies to every other host
+	RateLimits map[string]transport.Limit
+
+	// How many times a failed or rate limited request is retried
+	Retries *int
+
+	// Command run for each alert, e.g. when a reserved username is created. The alert is added as its last argument.
+	Notify []string
+}
+
+var (
+	configFile = totemPath + "/Config.yaml"
+
+	// --base-url platform=url, takes priority over Config.yaml
+	baseURLFlag map[string]string
+
+	// totem run --full
+	fullScanFlag bool
+)
+
+func loadConfig() totemConfig {
+	var config totemConfig
+
+	if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
+		return config
+	}
+
+	bytes, err := os.ReadFile(configFile)
+	if err != nil {
+		panic(err)
+	}
+
+	err = yaml.Unmarshal(bytes, &config)
+	if err != nil {
+		panic(err)
+	}
+
+	return config
+}
+
+// A client that rate limits & retries requests as configured
+func (c totemConfig) httpClient() *http.Client {
+	transportConfig := transport.DefaultConfig
+	transportConfig.Limits = make(map[string]transport.Limit)
+
+	for host, limit := range c.RateLimits {
+		if host == "*" {
+			transportConfig.DefaultLimit = limit
+		} else {
+			transportConfig.Limits[host] = limit
+		}
+	}
+	if c.Retries != nil {
+		transportConfig.MaxRetries = *c.Retries
+	}
+
+	return &http.Client{Transport: transport.New(nil, transportConfig)}
+}
+
+// The service.Config for platform, with the command line flags applied
+func (c totemConfig) serviceConfig(platform string) service.Config {
+	baseURL := c.BaseURLs[platform]
+	if u, ok := baseURLFlag[platform]; ok {
+		baseURL = u
+	}
+
+	return service.Config{
+		HTTPClient: httpClient,
+		BaseURL:    baseURL,
+		FullScan:   fullScanFlag,
+		Blobs:      blobstore.New(totemPath + "/.Blobs"),
+		Catalog:    catalogDB,
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"Totem/catalog"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How one account used one camera
+type deviceUse struct {
+	Account catalog.AccountRef
+
+	// Make & model, lower case so differently cased metadata is the same device
+	Device string
+
+	// Make & model as first seen
+	Name string
+
+	// The make as first seen, e.g. "Apple"
+	Make string
+
+	Posts int
+	First time.Time
+	Last  time.Time
+}
+
+// A device used by the accounts of more than one target
+type sharedDevice struct {
+	Name string
+	Uses []deviceUse
+}
+
+// The camera's make & model, without the make repeated if the model already starts with it
+func deviceName(maker, model string) string {
+	maker, model = strings.TrimSpace(maker), strings.TrimSpace(model)
+	if maker == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+		return model
+	}
+	return strings.TrimSpace(maker + " " + model)
+}
+
+// The make & model of the camera m was taken with: what its file's metadata says,
+// or what the platform says if the file does not say
+func mediaDevice(m catalog.Media) (string, string) {
+	if f := m.FileMetadata; f != nil && strings.TrimSpace(f.Model) != "" {
+		return strings.TrimSpace(f.Make), strings.TrimSpace(f.Model)
+	}
+	return strings.TrimSpace(m.Make), strings.TrimSpace(m.Model)
+}
+
+// Makes of mass-market phones, as their phones write them in EXIF. So many people post with these phones
+// that sharing one says nothing about who runs the accounts, however few archived accounts use it.
+var phoneMakes = map[string]struct{}{
+	"apple": {}, "samsung": {}, "google": {}, "xiaomi": {}, "huawei": {}, "honor": {}, "oneplus": {},
+	"oppo": {}, "vivo": {}, "realme": {}, "motorola": {}, "lge": {}, "hmd global": {},
+}
+
+func isPhoneMake(maker string) bool {
+	_, ok := phoneMakes[strings.ToLower(maker)]
+	return ok
+}
+
+// How many accounts with camera metadata are needed before a device's share of them tells how common it is
+const minAccountsForShare = 20
+
+// Groups media by account & device, leaving out media without a camera model.
+// Sorted by account, then by posts.
+func deviceUses(media []catalog.Media) []deviceUse {
+	type key struct {
+		account catalog.AccountRef
+		device  string
+	}
+
+	byKey := make(map[key]*deviceUse)
+	var uses []*deviceUse
+	for _, m := range media {
+		maker, model := mediaDevice(m)
+		name := deviceName(maker, model)
+		if name == "" {
+			continue
+		}
+
+		k := key{m.AccountRef, strings.ToLower(name)}
+		u, ok := byKey[k]
+		if !ok {
+			u = &deviceUse{Account: m.AccountRef, Device: k.device, Name: name, Make: maker, First: m.Time(), Last: m.Time()}
+			byKey[k] = u
+			uses = append(uses, u)
+		}
+		u.Posts++
+		if m.Time().Before(u.First) {
+			u.First = m.Time()
+		}
+		if m.Time().After(u.Last) {
+			u.Last = m.Time()
+		}
+	}
+
+	sorted := make([]deviceUse, len(uses))
+	for i, u := range uses {
+		sorted[i] = *u
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Account != b.Account {
+			return formatAccountRef(a.Account) < formatAccountRef(b.Account)
+		}
+		return a.Posts > b.Posts
+	})
+	return sorted
+}
+
+// Combines the uses of each device across accounts, keeping the account of the first use
+func combineDeviceUses(uses []deviceUse) []deviceUse {
+	byDevice := make(map[string]int)
+	var combined []deviceUse
+	for _, u := range uses {
+		i, ok := byDevice[u.Device]
+		if !ok {
+			byDevice[u.Device] = len(combined)
+			combined = append(combined, u)
+			continue
+		}
+
+		c := &combined[i]
+		c.Posts += u.Posts
+		if u.First.Before(c.First) {
+			c.First = u.First
+		}
+		if u.Last.After(c.Last) {
+			c.Last = u.Last
+		}
+	}
+
+	sort.SliceStable(combined, func(i, j int) bool {
+		return combined[i].Posts > combined[j].Posts
+	})
+	return combined
+}
+
+// The uncommon devices used by accounts of at least two targets.
+// Mass-market phones, by their make, are common, & once there are minAccountsForShare accounts with camera metadata
+// so is any device used by more than maxShare percent of them.
+// Sharing a common device says little about who runs the accounts.
+func sharedDevices(uses []deviceUse, maxShare float64) []sharedDevice {
+	byDevice := make(map[string][]deviceUse)
+	accounts := make(map[catalog.AccountRef]struct{})
+	var devices []string
+	for _, u := range uses {
+		if _, ok := byDevice[u.Device]; !ok {
+			devices = append(devices, u.Device)
+		}
+		byDevice[u.Device] = append(byDevice[u.Device], u)
+		accounts[u.Account] = struct{}{}
+	}
+
+	var shared []sharedDevice
+	for _, d := range devices {
+		deviceUses := byDevice[d]
+		targets := make(map[string]struct{})
+		for _, u := range deviceUses {
+			targets[u.Account.Target] = struct{}{}
+		}
+
+		common := false
+		for _, u := range deviceUses {
+			common = common || isPhoneMake(u.Make)
+		}
+		if len(accounts) >= minAccountsForShare {
+			common = common || float64(len(deviceUses))*100 > maxShare*float64(len(accounts))
+		}
+		if len(targets) >= 2 && !common {
+			shared = append(shared, sharedDevice{Name: deviceUses[0].Name, Uses: deviceUses})
+		}
+	}
+	return shared
+}
+
+// Prints the devices the selected targets', or every target's, accounts posted with
+// & the uncommon devices that are shared with other targets' accounts
+func printDevices(targetNames []string, maxShare float64) {
+	media, err := catalogDB.Media("")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	// Collected media was taken by someone else
+	var posts []catalog.Media
+	for _, m := range media {
+		if m.Collected == 0 {
+			posts = append(posts, m)
+		}
+	}
+
+	uses := deviceUses(posts)
+	byTarget := make(map[string][]deviceUse)
+	var targets []string
+	for _, u := range uses {
+		if len(targetNames) > 0 && !isSelected(u.Account.Target, targetNames) {
+			continue
+		}
+		if _, ok := byTarget[u.Account.Target]; !ok {
+			targets = append(targets, u.Account.Target)
+		}
+		byTarget[u.Account.Target] = append(byTarget[u.Account.Target], u)
+	}
+
+	printUse := func(indent string, u deviceUse) {
+		first := u.First.Format("Mon, Jan 2, 15h04m05s, MST 2006")
+		last := u.Last.Format("Mon, Jan 2, 15h04m05s, MST 2006")
+		fmt.Println(indent+u.Name, "|", u.Posts, "posts |", first, "-", last)
+	}
+
+	for _, target := range targets {
+		fmt.Println("--------" + target + "--------")
+		for _, u := range combineDeviceUses(byTarget[target]) {
+			printUse(" ", u)
+		}
+
+		var account catalog.AccountRef
+		for _, u := range byTarget[target] {
+			if u.Account != account {
+				account = u.Account
+				fmt.Println(" +", u.Account.Username, "["+u.Account.Platform+"]")
+			}
+			printUse("    ", u)
+		}
+	}
+	if len(targets) == 0 {
+		fmt.Println("No camera metadata found")
+	}
+
+	printedHeading := false
+	for _, d := range sharedDevices(uses, maxShare) {
+		involved := len(targetNames) == 0
+		accounts := make([]string, len(d.Uses))
+		for i, u := range d.Uses {
+			involved = involved || isSelected(u.Account.Target, targetNames)
+			accounts[i] = formatAccountRef(u.Account)
+		}
+		if !involved {
+			continue
+		}
+
+		if !printedHeading {
+			fmt.Println("--------Shared devices--------")
+			printedHeading = true
+		}
+		fmt.Println(" !!", d.Name, "is only used by", strings.Join(accounts, ", "))
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"Totem/service"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Media with the same contents stored in more than one place
+type duplicateMedia struct {
+	SHA256 string
+	Copies []mediaCopy
+}
+
+type mediaCopy struct {
+	TargetName string
+	Username   string
+	Platform   string
+	Source     string
+	Media      service.StoredMedia
+}
+
+// The number of targets holding the media
+func (d duplicateMedia) targets() int {
+	targets := make(map[string]struct{})
+	for _, c := range d.Copies {
+		targets[c.TargetName] = struct{}{}
+	}
+	return len(targets)
+}
+
+// Groups the stored media of every account by SHA-256, keeping the groups with more than one copy.
+// If targetNames are given, only groups with a copy held by one of them are kept.
+// Media stored before hashes were recorded is left out until `totem migrate-layout` hashes it.
+func findDuplicates(config totemConfig, targetNames []string, trackingProfiles []service.TrackingProfile) []duplicateMedia {
+	bySum := make(map[string]*duplicateMedia)
+	forEachStoredAccount(config, nil, trackingProfiles, func(targetName string, a *service.Account, s service.Service) {
+		lister, ok := s.(service.MediaLister)
+		if !ok {
+			return
+		}
+
+		for _, source := range s.MediaSources() {
+			media, err := lister.StoredMedia(source)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, a.Username, source.Name+":", err)
+				continue
+			}
+
+			for _, m := range media {
+				if m.SHA256 == "" {
+					continue
+				}
+				d, ok := bySum[m.SHA256]
+				if !ok {
+					d = &duplicateMedia{SHA256: m.SHA256}
+					bySum[m.SHA256] = d
+				}
+				d.Copies = append(d.Copies, mediaCopy{targetName, a.Username, a.Platform, source.Name, m})
+			}
+		}
+	})
+
+	var duplicates []duplicateMedia
+	for _, d := range bySum {
+		if len(d.Copies) < 2 {
+			continue
+		}
+		if len(targetNames) > 0 {
+			selected := false
+			for _, c := range d.Copies {
+				selected = selected || isSelected(c.TargetName, targetNames)
+			}
+			if !selected {
+				continue
+			}
+		}
+		duplicates = append(duplicates, *d)
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].SHA256 < duplicates[j].SHA256
+	})
+	return duplicates
+}
+
+func printDuplicates(duplicates []duplicateMedia) {
+	if len(duplicates) == 0 {
+		fmt.Println("No duplicate media found")
+	}
+	for _, d := range duplicates {
+		fmt.Println("sha256:", d.SHA256, "|", d.targets(), "targets")
+		for _, c := range d.Copies {
+			fmt.Println(" +", c.TargetName, "/", c.Username, "["+c.Platform+"]", c.Source, "|", c.Media.Dir)
+		}
+	}
+}
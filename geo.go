@@ -0,0 +1,128 @@
+package main
+
+import (
+	"Totem/catalog"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Writes the target's geotagged posts to outDir/{TargetName}.geojson & outDir/{TargetName}.kml.
+// Collected posts are only included if withCollected is set, as they are usually someone else's.
+func exportGeo(targetName string, outDir string, withCollected bool) error {
+	located := true
+	media, err := catalogDB.SearchMedia(catalog.Query{Target: targetName, HasLocation: &located})
+	if err != nil {
+		return err
+	}
+
+	var posts []catalog.Media
+	for _, m := range media {
+		if withCollected || m.Collected == 0 {
+			posts = append(posts, m)
+		}
+	}
+
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	geoJSON, err := encodeGeoJSON(posts)
+	if err != nil {
+		return err
+	}
+	kml, err := encodeKML(targetName, posts)
+	if err != nil {
+		return err
+	}
+
+	for file, data := range map[string][]byte{outDir + "/" + targetName + ".geojson": geoJSON, outDir + "/" + targetName + ".kml": kml} {
+		if err := os.WriteFile(file, data, os.ModePerm); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", len(posts), "posts to", file)
+	}
+	return nil
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONPoint      `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type string `json:"type"`
+
+	// Longitude, latitude
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	ID        string `json:"id"`
+	Account   string `json:"account"`
+	Platform  string `json:"platform"`
+	Source    string `json:"source"`
+	Timestamp string `json:"timestamp"`
+	Caption   string `json:"caption"`
+	Path      string `json:"path"`
+}
+
+func encodeGeoJSON(posts []catalog.Media) ([]byte, error) {
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, m := range posts {
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONPoint{Type: "Point", Coordinates: [2]float64{m.Longitude, m.Latitude}},
+			Properties: geoJSONProperties{
+				ID:        m.ID,
+				Account:   m.Username,
+				Platform:  m.Platform,
+				Source:    m.Source,
+				Timestamp: m.Time().UTC().Format(time.RFC3339),
+				Caption:   m.Description,
+				Path:      m.File,
+			},
+		})
+	}
+	return json.MarshalIndent(collection, "", "  ")
+}
+
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	When        string `xml:"TimeStamp>when"`
+	Coordinates string `xml:"Point>coordinates"`
+}
+
+func encodeKML(targetName string, posts []catalog.Media) ([]byte, error) {
+	document := kmlDocument{Name: targetName}
+	for _, m := range posts {
+		document.Placemarks = append(document.Placemarks, kmlPlacemark{
+			Name:        m.Username + " " + m.Source + " " + m.ID,
+			Description: m.Description + "\n" + m.File,
+			When:        m.Time().UTC().Format(time.RFC3339),
+			Coordinates: strconv.FormatFloat(m.Longitude, 'f', -1, 64) + "," + strconv.FormatFloat(m.Latitude, 'f', -1, 64),
+		})
+	}
+
+	data, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
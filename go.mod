@@ -4,5 +4,6 @@ go 1.16
 
 require (
 	github.com/spf13/cobra v1.5.0
+	go.etcd.io/bbolt v1.3.7
 	gopkg.in/yaml.v3 v3.0.1
 )
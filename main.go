@@ -1,12 +1,21 @@
 package main
 
 import (
+	"Totem/catalog"
+	"Totem/service"
 	"Totem/vscoservice"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -14,10 +23,15 @@ import (
 
 var (
 	httpClient   = http.DefaultClient
+	catalogDB    *catalog.DB
 	home, _      = os.UserHomeDir()
 	desktop      = home + "/Desktop"
 	totemPath    = desktop + "/Totem"
 	trackingFile = totemPath + "/Tracking.yaml"
+	catalogFile  = totemPath + "/.Catalog.db"
+
+	// Written once the accounts archived by older versions have been moved, see migrateAccountDirs
+	accountDirsMigratedFile = totemPath + "/.AccountDirsMigrated"
 )
 
 var (
@@ -38,23 +52,36 @@ func main() {
 	}
 
 	os.Chdir(totemPath)
+	config := loadConfig()
+	httpClient = config.httpClient()
 	trackingProfiles := deserializeTrackingProfiles()
+	migrateAccountDirs(config, trackingProfiles)
+
+	rootCMD.PersistentFlags().StringToStringVar(&baseURLFlag, "base-url", nil, "API base URL for a platform, e.g. vsco=http://localhost:8080/api/2.0")
 
 	runCMD := &cobra.Command{
-		Use:   "run",
-		Short: "Runs all active profiles, or runs profiles selected.",
-		Args:  cobra.ArbitraryArgs,
+		Use:    "run",
+		Short:  "Runs all active profiles, or runs profiles selected.",
+		Args:   cobra.ArbitraryArgs,
+		PreRun: openCatalog(false),
 		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			var report runReport
 			if len(args) == 0 {
-				runAllActiveTrackingProfiles(trackingProfiles)
+				report = runAllActiveTrackingProfiles(ctx, config, trackingProfiles)
 			} else {
 				for _, a := range args {
-					runTrackingProfile(a, trackingProfiles)
+					report.add(runTrackingProfile(ctx, config, a, trackingProfiles))
 				}
 			}
+			printRunReport(report)
+			notify(config, report.alerts())
 		},
 	}
 
+	runCMD.Flags().BoolVar(&fullScanFlag, "full", false, "Page through all media instead of stopping at media that is already archived")
+
 	printCMD := &cobra.Command{
 		Use:   "print",
 		Short: "Prints out all profiles",
@@ -68,73 +95,433 @@ func main() {
 		Short: "Prints out a profile's bios",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			getBioInfoForTrackingProfile(args[0], trackingProfiles)
+			getBioInfoForTrackingProfile(cmd.Context(), config, args[0], trackingProfiles)
+		},
+	}
+
+	avatarsCMD := &cobra.Command{
+		Use:   "avatars",
+		Short: "Prints out a profile's profile images & when each was seen",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			getProfileImagesForTrackingProfile(cmd.Context(), config, args[0], trackingProfiles)
+		},
+	}
+
+	changesCMD := &cobra.Command{
+		Use:   "changes",
+		Short: "Prints out what has changed on a profile's accounts, e.g. deleted posts",
+		Long: "Prints out what has changed on a profile's accounts, e.g. deleted posts.\n" +
+			"Deleted posts, & edits to posts older than the 20 most recent archived ones, are only noticed by `totem run --full`.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printChangesForTrackingProfile(args[0], trackingProfiles)
+		},
+	}
+
+	migrateLayoutCMD := &cobra.Command{
+		Use:    "migrate-layout",
+		Short:  "Renames all profiles' archived media to the current layout, or renames profiles selected.",
+		Args:   cobra.ArbitraryArgs,
+		PreRun: openCatalog(false),
+		Run: func(cmd *cobra.Command, args []string) {
+			migrateLayoutForTrackingProfiles(config, args, "", trackingProfiles)
+		},
+	}
+
+	repairCollectionCMD := &cobra.Command{
+		Use:    "repair-collection",
+		Short:  "Re-keys all profiles' collected media by collection time & media ID, or re-keys profiles selected.",
+		Args:   cobra.ArbitraryArgs,
+		PreRun: openCatalog(false),
+		Run: func(cmd *cobra.Command, args []string) {
+			migrateLayoutForTrackingProfiles(config, args, "Collection", trackingProfiles)
+		},
+	}
+
+	reindexCMD := &cobra.Command{
+		Use:    "reindex",
+		Short:  "Rebuilds the catalog from what is archived, for all profiles or for profiles selected.",
+		Args:   cobra.ArbitraryArgs,
+		PreRun: openCatalog(false),
+		Run: func(cmd *cobra.Command, args []string) {
+			reindexTrackingProfiles(config, args, trackingProfiles)
+		},
+	}
+
+	var query catalog.Query
+	var from, to string
+	var hasLocation bool
+	searchCMD := &cobra.Command{
+		Use:    "search [text]",
+		Short:  "Searches archived posts & bios, e.g. totem search sunset --target BobTheTarget --from 2021-06-01",
+		Args:   cobra.ArbitraryArgs,
+		PreRun: openCatalog(true),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 0 {
+				query.Text = strings.TrimSpace(query.Text + " " + strings.Join(args, " "))
+			}
+			if cmd.Flags().Changed("has-location") {
+				query.HasLocation = &hasLocation
+			}
+			if query.MediaType != "" && query.MediaType != "image" && query.MediaType != "video" {
+				fmt.Fprintln(os.Stderr, "--type must be image or video")
+				return
+			}
+
+			var err error
+			if from != "" {
+				query.From, err = parseSearchDate(from, false)
+			}
+			if err == nil && to != "" {
+				query.To, err = parseSearchDate(to, true)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+
+			searchCatalog(query)
+		},
+	}
+
+	searchCMD.Flags().StringVar(&query.Target, "target", "", "Only search this target")
+	searchCMD.Flags().StringVar(&query.Account, "account", "", "Only search the account with this username")
+	searchCMD.Flags().StringVar(&from, "from", "", "Only match posts & bios from this date on, YYYY-MM-DD")
+	searchCMD.Flags().StringVar(&to, "to", "", "Only match posts & bios up to this date, YYYY-MM-DD")
+	searchCMD.Flags().StringVar(&query.Text, "text", "", "Only match captions & bios containing this text")
+	searchCMD.Flags().StringVar(&query.Tag, "tag", "", "Only match posts with this tag slug")
+	searchCMD.Flags().StringVar(&query.Make, "make", "", "Only match posts taken with a camera of this make")
+	searchCMD.Flags().StringVar(&query.Model, "model", "", "Only match posts taken with this camera model")
+	searchCMD.Flags().BoolVar(&hasLocation, "has-location", false, "Only match posts with a location, or without one if false")
+	searchCMD.Flags().StringVar(&query.MediaType, "type", "", "Only match posts of this type, image or video")
+	searchCMD.Flags().BoolVar(&query.Discrepancies, "discrepancies", false, "Only match posts whose file's metadata disagrees with the platform")
+
+	exportCMD := &cobra.Command{
+		Use:   "export",
+		Short: "Exports archived data for use in other tools",
+	}
+
+	var exportDir string
+	var withCollected bool
+	exportGeoCMD := &cobra.Command{
+		Use:    "geo",
+		Short:  "Exports a profile's geotagged posts as GeoJSON & KML",
+		Args:   cobra.ExactArgs(1),
+		PreRun: openCatalog(true),
+		Run: func(cmd *cobra.Command, args []string) {
+			if exportDir == "" {
+				exportDir = totemPath + "/Exports"
+			}
+			if err := exportGeo(args[0], exportDir, withCollected); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		},
+	}
+
+	exportGeoCMD.Flags().StringVar(&exportDir, "out", "", "Directory to write the files to, Totem/Exports by default")
+	exportGeoCMD.Flags().BoolVar(&withCollected, "collected", false, "Include geotagged posts the profile collected")
+	exportCMD.AddCommand(exportGeoCMD)
+
+	var radius float64
+	var minPosts int
+	var placesOffset string
+	placesCMD := &cobra.Command{
+		Use:    "places",
+		Short:  "Prints out the places a profile's geotagged posts cluster around, e.g. home & work",
+		Args:   cobra.ExactArgs(1),
+		PreRun: openCatalog(true),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := printPlaces(args[0], radius, minPosts, placesOffset); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		},
+	}
+
+	placesCMD.Flags().Float64Var(&radius, "radius", 250, "Posts this many metres apart can be at the same place")
+	placesCMD.Flags().IntVar(&minPosts, "min-posts", 3, "Posts needed within --radius of a post to start a place")
+	placesCMD.Flags().StringVar(&placesOffset, "offset", "", "Read times of day in this UTC offset, e.g. +02:00, instead of the estimated one")
+
+	var offset, pngFile, svgFile string
+	activityCMD := &cobra.Command{
+		Use:    "activity",
+		Short:  "Prints out when a profile posts by weekday & hour, & estimates their UTC offset",
+		Args:   cobra.ExactArgs(1),
+		PreRun: openCatalog(true),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := printActivity(args[0], offset, pngFile, svgFile); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		},
+	}
+
+	activityCMD.Flags().StringVar(&offset, "offset", "", "Show times in this UTC offset, e.g. +02:00, instead of the estimated one")
+	activityCMD.Flags().StringVar(&pngFile, "png", "", "Also write the heatmap to this PNG file")
+	activityCMD.Flags().StringVar(&svgFile, "svg", "", "Also write the heatmap to this SVG file")
+
+	var maxShare float64
+	devicesCMD := &cobra.Command{
+		Use:    "devices",
+		Short:  "Prints out the cameras all profiles, or profiles selected, posted with & flags uncommon ones shared between profiles",
+		Args:   cobra.ArbitraryArgs,
+		PreRun: openCatalog(true),
+		Run: func(cmd *cobra.Command, args []string) {
+			printDevices(args, maxShare)
+		},
+	}
+
+	devicesCMD.Flags().Float64Var(&maxShare, "max-share", 10, "Only flag devices used by at most this percentage of archived accounts, once there are enough to tell")
+
+	duplicatesCMD := &cobra.Command{
+		Use:   "duplicates",
+		Short: "Prints out identical media stored by more than one account, or by the profiles selected & any other account.",
+		Args:  cobra.ArbitraryArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			printDuplicates(findDuplicates(config, args, trackingProfiles))
 		},
 	}
 
 	rootCMD.AddCommand(runCMD)
 	rootCMD.AddCommand(printCMD)
 	rootCMD.AddCommand(bioCMD)
-
-	Execute()
+	rootCMD.AddCommand(avatarsCMD)
+	rootCMD.AddCommand(changesCMD)
+	rootCMD.AddCommand(migrateLayoutCMD)
+	rootCMD.AddCommand(repairCollectionCMD)
+	rootCMD.AddCommand(duplicatesCMD)
+	rootCMD.AddCommand(reindexCMD)
+	rootCMD.AddCommand(searchCMD)
+	rootCMD.AddCommand(exportCMD)
+	rootCMD.AddCommand(placesCMD)
+	rootCMD.AddCommand(activityCMD)
+	rootCMD.AddCommand(devicesCMD)
+
+	// The first SIGINT/SIGTERM cancels the context so in-flight writes can finish
+	// & Tracking.yaml still gets saved, a second one exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		fmt.Fprintln(os.Stderr, "Stopping after in-flight downloads finish, interrupt again to quit immediately.")
+	}()
+
+	Execute(ctx)
 
 	serializeTrackingProfiles(&trackingProfiles)
+	if catalogDB != nil {
+		catalogDB.Close()
+	}
 }
 
-func Execute() {
-	if err := rootCMD.Execute(); err != nil {
+// Opens the catalog before a command that uses it, exiting if it cannot be opened.
+// Commands that only read the catalog open it read-only, so they can run alongside one that writes it.
+func openCatalog(readOnly bool) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		var err error
+		if readOnly {
+			catalogDB, err = catalog.OpenReadOnly(catalogFile)
+		} else {
+			catalogDB, err = catalog.Open(catalogFile)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func Execute(ctx context.Context) {
+	if err := rootCMD.ExecuteContext(ctx); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func runAllActiveTrackingProfiles(trackingProfiles []vscoservice.VSCOTrackingProfile) {
-	for i := range trackingProfiles {
-		if trackingProfiles[i].Active {
-			getUser(&trackingProfiles[i])
+// Prints out the bio history of each of the target's accounts.
+// Only what runs recorded is printed, accounts are not looked up so their status only changes during runs.
+func getBioInfoForTrackingProfile(ctx context.Context, config totemConfig, targetName string, trackingProfiles []service.TrackingProfile) {
+	forEachStoredAccount(config, []string{targetName}, trackingProfiles, func(_ string, a *service.Account, s service.Service) {
+		if err := s.PrintBio(ctx, false); err != nil {
+			fmt.Fprintln(os.Stderr, a.Username+":", err)
 		}
+		printIdentityChanges(a, "")
+	})
+}
+
+// Prints out the profile image history of each of the target's accounts, like getBioInfoForTrackingProfile
+func getProfileImagesForTrackingProfile(ctx context.Context, config totemConfig, targetName string, trackingProfiles []service.TrackingProfile) {
+	forEachStoredAccount(config, []string{targetName}, trackingProfiles, func(_ string, a *service.Account, s service.Service) {
+		if err := s.PrintProfileImages(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, a.Username+":", err)
+		}
+	})
+}
+
+// Renames the archived media of the selected targets, or of every target if none are selected, to the current layout.
+// Only media sources named sourceName are renamed, unless it is empty. Accounts are not looked up on their platform.
+func migrateLayoutForTrackingProfiles(config totemConfig, targetNames []string, sourceName string, trackingProfiles []service.TrackingProfile) {
+	forEachStoredAccount(config, targetNames, trackingProfiles, func(targetName string, a *service.Account, s service.Service) {
+		migrator, ok := s.(service.LayoutMigrator)
+		if !ok {
+			return
+		}
+
+		for _, source := range s.MediaSources() {
+			if sourceName != "" && source.Name != sourceName {
+				continue
+			}
+
+			renamed, err := migrator.MigrateLayout(source)
+			if renamed > 0 {
+				fmt.Println(targetName, "/", a.Username, source.Name+":", "renamed", renamed, "media")
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, a.Username, source.Name+":", err)
+			}
+		}
+	})
+}
+
+// Replaces the catalog's records of the selected targets, or the whole catalog if none are selected,
+// with ones read from what is archived.
+func reindexTrackingProfiles(config totemConfig, targetNames []string, trackingProfiles []service.TrackingProfile) {
+	var err error
+	if len(targetNames) == 0 {
+		err = catalogDB.Clear()
+	}
+	for _, name := range targetNames {
+		if err == nil {
+			err = catalogDB.RemoveTarget(name)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
 	}
+
+	for _, tp := range trackingProfiles {
+		if len(targetNames) == 0 || isSelected(tp.TargetName, targetNames) {
+			if err := catalogDB.PutTarget(catalog.Target{Name: tp.TargetName, Active: tp.Active}); err != nil {
+				fmt.Fprintln(os.Stderr, tp.TargetName+":", err)
+			}
+		}
+	}
+
+	var accounts int
+	forEachStoredAccount(config, targetNames, trackingProfiles, func(targetName string, a *service.Account, s service.Service) {
+		reindexer, ok := s.(service.Reindexer)
+		if !ok {
+			return
+		}
+
+		if err := reindexer.Reindex(); err != nil {
+			fmt.Fprintln(os.Stderr, a.Username+":", err)
+			return
+		}
+		accounts++
+	})
+	fmt.Println("Reindexed", accounts, "accounts")
 }
 
-func runTrackingProfile(targetName string, trackingProfiles []vscoservice.VSCOTrackingProfile) {
+// Calls f with the service of each account of the selected targets, or of every target if none are selected.
+// Accounts are not looked up on their platform, so only what is already stored can be used.
+func forEachStoredAccount(config totemConfig, targetNames []string, trackingProfiles []service.TrackingProfile, f func(targetName string, a *service.Account, s service.Service)) {
 	for i := range trackingProfiles {
-		if trackingProfiles[i].TargetName == targetName {
-			getUser(&trackingProfiles[i])
+		tp := &trackingProfiles[i]
+		if len(targetNames) > 0 && !isSelected(tp.TargetName, targetNames) {
+			continue
+		}
+
+		userpath := totemPath + "/" + tp.TargetName
+		for k := range tp.Accounts {
+			a := &tp.Accounts[k]
+
+			s, err := service.New(a.Platform, a, userpath, config.serviceConfig(a.Platform))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, a.Username+":", err)
+				continue
+			}
+			f(tp.TargetName, a, s)
 		}
 	}
 }
 
-func getBioInfoForTrackingProfile(targetName string, trackingProfiles []vscoservice.VSCOTrackingProfile) {
-	// For each account, print out the bio history
-	for i := range trackingProfiles {
-		if trackingProfiles[i].TargetName == targetName {
-			userpath := totemPath + "/" + trackingProfiles[i].TargetName
-			for k := range trackingProfiles[i].Accounts {
-				accountPath := userpath + "/" + trackingProfiles[i].Accounts[k].Username
-				os.Chdir(accountPath)
+func isSelected(targetName string, targetNames []string) bool {
+	for _, n := range targetNames {
+		if n == targetName {
+			return true
+		}
+	}
+	return false
+}
 
-				service := vscoservice.New(&trackingProfiles[i].Accounts[k], userpath)
-				service.PrintBio(false)
+func printChangesForTrackingProfile(targetName string, trackingProfiles []service.TrackingProfile) {
+	// For each account, print out the history
+	for _, tp := range trackingProfiles {
+		if tp.TargetName == targetName {
+			for _, a := range tp.Accounts {
+				accountPath := service.AccountDir(totemPath+"/"+tp.TargetName, a.Platform, a.Username)
+
+				events, err := service.ReadHistory(accountPath)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, a.Username+":", err)
+					continue
+				}
 
-				os.Chdir("..")
+				fmt.Println("User:", a.Username)
+				if len(events) == 0 {
+					fmt.Println("No changes recorded")
+				}
+				for _, e := range events {
+					ts := time.Unix(e.Time, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+					fmt.Println(ts, "|", e.Description())
+				}
 			}
 		}
 	}
 }
 
-func printTrackingProfiles(trackingProfiles []vscoservice.VSCOTrackingProfile) {
+func printTrackingProfiles(trackingProfiles []service.TrackingProfile) {
 	for _, tp := range trackingProfiles {
 		fmt.Println("--------" + tp.TargetName + "--------")
 		fmt.Println("Active:", tp.Active)
 		fmt.Println("Accounts:")
 		for _, a := range tp.Accounts {
-			fmt.Println(" +", a.Username, "("+strconv.Itoa(a.UserID)+")")
+			fmt.Println(" +", a.Username, "["+a.Platform+"]", "("+formatAccountIDs(a.IDs)+")", formatStatus(a.Status))
+			for _, c := range a.StatusChanges {
+				ts := time.Unix(c.At, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+				fmt.Println("   "+ts, "|", "status", formatStatus(c.From), "->", c.To)
+			}
+			printIdentityChanges(&a, "   ")
 		}
 	}
 }
 
-func deserializeTrackingProfiles() []vscoservice.VSCOTrackingProfile {
-	var trackingProfiles []vscoservice.VSCOTrackingProfile
+// Prints the account's previous usernames, each line starting with indent
+func printIdentityChanges(a *service.Account, indent string) {
+	for _, c := range a.Identities {
+		ts := time.Unix(c.DetectedAt, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+		fmt.Println(indent+ts, "|", strings.Replace(c.Field, "_", " ", -1), c.Old, "->", c.New)
+	}
+}
+
+// Formats an account's identifiers as "key: value, ..." sorted by key
+func formatAccountIDs(ids map[string]string) string {
+	keys := make([]string, 0, len(ids))
+	for k := range ids {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + ": " + ids[k]
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func deserializeTrackingProfiles() []service.TrackingProfile {
+	var legacyProfiles []legacyTrackingProfile
 
 	if _, err := os.Stat(trackingFile); errors.Is(err, os.ErrNotExist) {
 		return nil
@@ -145,56 +532,226 @@ func deserializeTrackingProfiles() []vscoservice.VSCOTrackingProfile {
 		panic(err)
 	}
 
-	err = yaml.Unmarshal(bytes, &trackingProfiles)
+	err = yaml.Unmarshal(bytes, &legacyProfiles)
 	if err != nil {
 		panic(err)
 	}
 
+	trackingProfiles, migrated := migrateTrackingProfiles(legacyProfiles)
+
+	// Keep a copy of the old file, the new schema is written when totem exits
+	if migrated {
+		err = os.WriteFile(trackingFile+".bak", bytes, os.ModePerm)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Migrated", trackingFile, "to the platform schema, the old file was saved to", trackingFile+".bak")
+	}
+
 	return trackingProfiles
 }
 
-func serializeTrackingProfiles(profiles *[]vscoservice.VSCOTrackingProfile) {
-	bytes, err := yaml.Marshal(profiles)
-	if err != nil {
-		panic(err)
+// A Tracking.yaml entry that may still be in the VSCO-only schema:
+//
+//	accounts:
+//	  - userid: -1
+//	    siteid: -1
+//	    username: bob
+type legacyTrackingProfile struct {
+	TargetName string
+	Active     bool
+	Accounts   []struct {
+		service.Account `yaml:",inline"`
+
+		// VSCO-only schema, -1 when unknown
+		UserID *int
+		SiteID *int
 	}
+}
 
-	err = os.WriteFile(trackingFile, bytes, os.ModePerm)
-	if err != nil {
-		panic(err)
+// Converts legacy tracking profiles to the platform schema.
+// Accounts without a platform are VSCO accounts, the ones without ids are reserved usernames.
+func migrateTrackingProfiles(legacyProfiles []legacyTrackingProfile) ([]service.TrackingProfile, bool) {
+	migrated := false
+
+	trackingProfiles := make([]service.TrackingProfile, len(legacyProfiles))
+	for i, lp := range legacyProfiles {
+		trackingProfiles[i] = service.TrackingProfile{
+			TargetName: lp.TargetName,
+			Active:     lp.Active,
+			Accounts:   make([]service.Account, len(lp.Accounts)),
+		}
+
+		for k, la := range lp.Accounts {
+			a := la.Account
+
+			if a.Platform == "" {
+				migrated = true
+				a.Platform = vscoservice.Platform
+				if la.SiteID != nil && *la.SiteID != -1 {
+					a.SetID(vscoservice.SiteIDKey, strconv.Itoa(*la.SiteID))
+				}
+				if la.UserID != nil && *la.UserID != -1 {
+					a.SetID(vscoservice.UserIDKey, strconv.Itoa(*la.UserID))
+				}
+
+				// Older versions filled in the ids as soon as they found the account, so one without any was never found
+				if len(a.IDs) == 0 && a.Status == "" {
+					a.Status = service.StatusNeverExisted
+				}
+			}
+
+			trackingProfiles[i].Accounts[k] = a
+		}
 	}
+
+	return trackingProfiles, migrated
 }
 
-// Runs vscoservice on a TrackingProfile & all of it's accounts
-func getUser(tp *vscoservice.VSCOTrackingProfile) {
-	userpath := totemPath + "/" + tp.TargetName
+// Moves the VSCO accounts archived by older versions, which kept them in {Target}/{Username},
+// to service.AccountDir. All of a target's accounts are moved aside before any is moved into place,
+// so an account named like a platform does not end up inside its own platform's directory.
+// Accounts an earlier migration left aside are moved into place, or back if that fails.
+// Once nothing is left to move accountDirsMigratedFile is written, & later calls return straight away.
+// The catalog's records of the moved accounts are rebuilt, as they point at the old directories.
+func migrateAccountDirs(config totemConfig, trackingProfiles []service.TrackingProfile) {
+	if _, err := os.Stat(accountDirsMigratedFile); err == nil {
+		return
+	}
+
+	type move struct {
+		old, tmp, new string
+		account       service.Account
+	}
+
+	failed := false
+	var moved []move
 
-	if _, err := os.Stat(userpath); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(userpath, os.ModePerm)
+	// Moves the account from aside into place, or back where it was if that fails
+	finish := func(m move) {
+		err := os.MkdirAll(filepath.Dir(m.new), os.ModePerm)
+		if err == nil {
+			err = os.Rename(m.tmp, m.new)
+		}
+		if err == nil {
+			fmt.Println("Moved", m.old, "to", m.new)
+			moved = append(moved, m)
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+
+		if _, statErr := os.Stat(m.old); errors.Is(statErr, os.ErrNotExist) {
+			err = os.Rename(m.tmp, m.old)
+		}
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, "Could not move", m.tmp, "back to", m.old+", it is moved again on the next start:", err)
 		}
+		failed = true
 	}
 
-	os.Chdir(userpath)
+	accounts := make(map[string]service.Account)
+	for _, tp := range trackingProfiles {
+		for _, a := range tp.Accounts {
+			if a.Platform == vscoservice.Platform {
+				accounts[tp.TargetName+"/"+a.Username] = a
+			}
+		}
+	}
 
-	for i, a := range tp.Accounts {
-		accountPath := userpath + "/" + a.Username
-		if _, err := os.Stat(accountPath); errors.Is(err, os.ErrNotExist) {
-			err := os.Mkdir(accountPath, os.ModePerm)
-			if err != nil {
-				panic(err)
+	// Left aside by a migration that was interrupted or failed
+	leftovers, err := filepath.Glob(totemPath + "/*/.migrating-*")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	for _, tmp := range leftovers {
+		targetDir := filepath.Dir(tmp)
+		username := strings.TrimPrefix(filepath.Base(tmp), ".migrating-")
+		a, ok := accounts[filepath.Base(targetDir)+"/"+username]
+		if !ok {
+			a = service.Account{Platform: vscoservice.Platform, Username: username}
+		}
+		finish(move{targetDir + "/" + username, tmp, service.AccountDir(targetDir, vscoservice.Platform, username), a})
+	}
+
+	for _, tp := range trackingProfiles {
+		targetDir := totemPath + "/" + tp.TargetName
+
+		var moves []move
+		for _, a := range tp.Accounts {
+			m := move{targetDir + "/" + a.Username, targetDir + "/.migrating-" + a.Username, service.AccountDir(targetDir, a.Platform, a.Username), a}
+			if a.Platform != vscoservice.Platform || !isAccountDir(m.old) {
+				continue
+			}
+			if _, err := os.Stat(m.new); err == nil {
+				continue
+			} else if !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintln(os.Stderr, tp.TargetName, "/", a.Username+":", err)
+				failed = true
+				continue
+			}
+
+			if err := os.Rename(m.old, m.tmp); err != nil {
+				fmt.Fprintln(os.Stderr, tp.TargetName, "/", a.Username+":", err)
+				failed = true
+				continue
+			}
+			moves = append(moves, m)
+		}
+
+		for _, m := range moves {
+			finish(m)
+		}
+	}
+
+	if !failed {
+		if err := os.WriteFile(accountDirsMigratedFile, nil, os.ModePerm); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	if _, err := os.Stat(catalogFile); len(moved) == 0 || err != nil {
+		return
+	}
+	db, err := catalog.Open(catalogFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer db.Close()
+
+	cfg := config.serviceConfig(vscoservice.Platform)
+	cfg.Catalog = db
+	for _, m := range moved {
+		s, err := service.New(m.account.Platform, &m.account, filepath.Dir(filepath.Dir(m.new)), cfg)
+		if err == nil {
+			if reindexer, ok := s.(service.Reindexer); ok {
+				err = reindexer.Reindex()
 			}
 		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, m.account.Username+":", err)
+		}
+	}
+}
 
-		os.Chdir(accountPath)
+func isAccountDir(dir string) bool {
+	for _, name := range []string{"Profile", "Gallery", "Collection", ".History.json"} {
+		if _, err := os.Stat(dir + "/" + name); err == nil {
+			return true
+		}
+	}
+	return false
+}
 
-		service := vscoservice.New(&tp.Accounts[i], userpath)
-		service.CheckBio()
-		service.CheckProfileImage()
-		service.CheckGalleryMedia()
-		service.CheckCollectionMedia()
+func serializeTrackingProfiles(profiles *[]service.TrackingProfile) {
+	bytes, err := yaml.Marshal(profiles)
+	if err != nil {
+		panic(err)
+	}
 
-		os.Chdir("..")
+	err = os.WriteFile(trackingFile, bytes, os.ModePerm)
+	if err != nil {
+		panic(err)
 	}
 }
@@ -0,0 +1,1394 @@
+package main
+
+import (
+	"Totem/catalog"
+	"Totem/service"
+	"Totem/transport"
+	"Totem/vscoservice"
+	"Totem/vscoservice/vscofake"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"image/png"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Points runs at a temporary Totem directory & a fake VSCO server
+func setupFake(t *testing.T) (*vscofake.Server, totemConfig) {
+	fake := vscofake.NewServer()
+
+	oldTotemPath, oldHTTPClient, oldCatalogDB := totemPath, httpClient, catalogDB
+	totemPath = t.TempDir()
+	httpClient = fake.Client()
+
+	db, err := catalog.Open(totemPath + "/.Catalog.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogDB = db
+
+	t.Cleanup(func() {
+		db.Close()
+		totemPath, httpClient, catalogDB = oldTotemPath, oldHTTPClient, oldCatalogDB
+		fake.Close()
+	})
+
+	return fake, totemConfig{BaseURLs: map[string]string{vscoservice.Platform: fake.BaseURL()}}
+}
+
+func newTrackingProfile(usernames ...string) *service.TrackingProfile {
+	tp := &service.TrackingProfile{TargetName: "Target", Active: true}
+	for _, u := range usernames {
+		tp.Accounts = append(tp.Accounts, service.Account{Platform: vscoservice.Platform, Username: u})
+	}
+	return tp
+}
+
+func runGetUser(t *testing.T, config totemConfig, tp *service.TrackingProfile) {
+	t.Helper()
+
+	for _, f := range getUser(context.Background(), config, tp).Failures {
+		t.Errorf("%s / %s %s: %v", f.TargetName, f.Username, f.Step, f.Err)
+	}
+}
+
+func media(id string, uploadSecond int64) vscoservice.VSCOMedia {
+	return vscoservice.VSCOMedia{ID: id, UploadDate: (1600000000 + uploadSecond) * 1000, Description: "caption " + id}
+}
+
+// The contents of every media file below dir, by file name
+func mediaFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(path, ".json") {
+			return err
+		}
+		bytes, err := os.ReadFile(path)
+		files[info.Name()] = string(bytes)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func readBioRecords(t *testing.T, accountDir string) []vscoservice.BioRecord {
+	t.Helper()
+
+	var records []vscoservice.BioRecord
+	bytes, err := os.ReadFile(accountDir + "/Profile/.BioRecord.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(bytes, &records); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func readMediaIndex(t *testing.T, dir string) vscoservice.MediaIndex {
+	t.Helper()
+
+	var index vscoservice.MediaIndex
+	bytes, err := os.ReadFile(dir + "/.Index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(bytes, &index); err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func countRequests(fake *vscofake.Server, prefix string) int {
+	n := 0
+	for _, r := range fake.Requests() {
+		if strings.HasPrefix(r, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGetUserArchivesAccount(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "first bio")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+	video := media("g2", 2)
+	video.IsVideo = true
+	fake.AddGalleryMedia(site.SiteID, video, []byte("video g2"))
+	fake.AddCollectionMedia(site.SiteID, vscoservice.VSCOMedia{ID: "c1", UploadDate: 1500000000000, CollectedDate: 1000}, []byte("image c1"))
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	a := tp.Accounts[0]
+	if a.ID(vscoservice.SiteIDKey) != strconv.Itoa(site.SiteID) || a.ID(vscoservice.UserIDKey) != strconv.Itoa(site.UserID) {
+		t.Errorf("account ids = %v, want site %d & user %d", a.IDs, site.SiteID, site.UserID)
+	}
+
+	accountDir := totemPath + "/Target/vsco/bob"
+	if records := readBioRecords(t, accountDir); len(records) != 1 || records[0].Description != "first bio" {
+		t.Errorf("bio records = %+v", records)
+	}
+	if _, err := os.Stat(accountDir + "/Profile/" + site.ProfileImageID + ".jpg"); err != nil {
+		t.Errorf("profile image not saved: %v", err)
+	}
+
+	gallery := mediaFiles(t, accountDir+"/Gallery")
+	if len(gallery) != 2 || gallery["g1.jpg"] != "image g1" || gallery["g2.mp4"] != "video g2" {
+		t.Errorf("gallery = %v", gallery)
+	}
+	collection := mediaFiles(t, accountDir+"/Collection")
+	if len(collection) != 1 || collection["c1.jpg"] != "image c1" {
+		t.Errorf("collection = %v", collection)
+	}
+}
+
+func TestGetUserFollowsRename(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	fake.RenameSite(site.SiteID, "bobby")
+	runGetUser(t, config, tp)
+
+	if tp.Accounts[0].Username != "bobby" {
+		t.Errorf("username = %q, want bobby", tp.Accounts[0].Username)
+	}
+	if ids := tp.Accounts[0].Identities; len(ids) != 1 || ids[0].Old != "bob" || ids[0].New != "bobby" || ids[0].DetectedAt == 0 {
+		t.Errorf("identity changes = %+v, want bob -> bobby", ids)
+	}
+	if events, _ := service.ReadHistory(totemPath + "/Target/vsco/bobby"); len(events) != 1 || events[0].Kind != service.EventIdentityChanged {
+		t.Errorf("history = %+v, want one identity change", events)
+	}
+	if _, err := os.Stat(totemPath + "/Target/vsco/bob"); !os.IsNotExist(err) {
+		t.Errorf("old account directory still exists: %v", err)
+	}
+	if gallery := mediaFiles(t, totemPath+"/Target/vsco/bobby/Gallery"); gallery["g1.jpg"] != "image g1" {
+		t.Errorf("gallery after rename = %v", gallery)
+	}
+	if media, err := catalogDB.Media("Target"); err != nil || len(media) != 1 || media[0].Username != "bobby" || !strings.HasPrefix(media[0].Dir, totemPath+"/Target/vsco/bobby/") {
+		t.Errorf("catalog media after rename = %+v, %v", media, err)
+	}
+}
+
+func TestGetUserKeepsDeletedAccount(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	fake.DeleteSite(site.SiteID)
+	runGetUser(t, config, tp)
+
+	if tp.Accounts[0].ID(vscoservice.SiteIDKey) != strconv.Itoa(site.SiteID) {
+		t.Errorf("site id lost after deletion: %v", tp.Accounts[0].IDs)
+	}
+	if gallery := mediaFiles(t, totemPath+"/Target/vsco/bob/Gallery"); gallery["g1.jpg"] != "image g1" {
+		t.Errorf("gallery after deletion = %v", gallery)
+	}
+}
+
+func TestGetUserRecordsBioChanges(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "first bio")
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+	runGetUser(t, config, tp)
+
+	fake.SetBio(site.SiteID, "second bio")
+	runGetUser(t, config, tp)
+
+	records := readBioRecords(t, totemPath+"/Target/vsco/bob")
+	if len(records) != 2 || records[0].Description != "first bio" || records[1].Description != "second bio" {
+		t.Errorf("bio records = %+v", records)
+	}
+}
+
+func TestGetUserDownloadsOnlyNewUploads(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	fake.AddGalleryMedia(site.SiteID, media("g2", 2), []byte("image g2"))
+	runGetUser(t, config, tp)
+
+	if gallery := mediaFiles(t, totemPath+"/Target/vsco/bob/Gallery"); len(gallery) != 2 || gallery["g2.jpg"] != "image g2" {
+		t.Errorf("gallery = %v", gallery)
+	}
+	if n := countRequests(fake, "/files/media/g1.jpg"); n != 1 {
+		t.Errorf("g1 downloaded %d times, want 1", n)
+	}
+}
+
+func TestGetUserContinuesAfterAccountFailure(t *testing.T) {
+	fake, config := setupFake(t)
+
+	fake.AddSite("bob", "")
+
+	tp := newTrackingProfile("bob")
+	tp.Accounts = append([]service.Account{{Platform: "nowhere", Username: "bob"}}, tp.Accounts...)
+
+	failures := getUser(context.Background(), config, tp).Failures
+	if len(failures) != 1 || failures[0].Platform != "nowhere" {
+		t.Errorf("failures = %+v, want one for platform nowhere", failures)
+	}
+	if tp.Accounts[1].ID(vscoservice.SiteIDKey) == "" {
+		t.Errorf("vsco account was not resolved after the failing account")
+	}
+}
+
+func TestGetUserRetriesRateLimitedRequests(t *testing.T) {
+	fake, config := setupFake(t)
+	httpClient = &http.Client{Transport: transport.New(fake.Client().Transport, transport.Config{
+		MaxRetries: 3,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+	})}
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+	fake.Fail("/api/2.0/medias", http.StatusTooManyRequests, 2)
+	fake.Fail("/files/media/g1.jpg", http.StatusServiceUnavailable, 1)
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	if gallery := mediaFiles(t, totemPath+"/Target/vsco/bob/Gallery"); gallery["g1.jpg"] != "image g1" {
+		t.Errorf("gallery = %v", gallery)
+	}
+}
+
+func TestGetUserReportsRateLimitWhenRetriesRunOut(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+	fake.Fail("/api/2.0/medias", http.StatusTooManyRequests, 1)
+
+	failures := getUser(context.Background(), config, newTrackingProfile("bob")).Failures
+	if len(failures) != 1 || !errors.Is(failures[0].Err, service.ErrRateLimited) {
+		t.Errorf("failures = %+v, want one rate limited", failures)
+	}
+}
+
+func TestGetUserRetriesInvalidDownloadsNextRun(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+	fake.AddGalleryMedia(site.SiteID, media("g2", 2), []byte("image g2"))
+	fake.ServeErrorPage("/files/media/g1.jpg", 1)
+
+	tp := newTrackingProfile("bob")
+	failures := getUser(context.Background(), config, tp).Failures
+	if len(failures) != 1 || !errors.Is(failures[0].Err, service.ErrTransport) {
+		t.Errorf("failures = %+v, want one transport failure", failures)
+	}
+	if gallery := mediaFiles(t, totemPath+"/Target/vsco/bob/Gallery"); len(gallery) != 1 || gallery["g2.jpg"] != "image g2" {
+		t.Errorf("gallery after error page = %v", gallery)
+	}
+
+	runGetUser(t, config, tp)
+
+	if gallery := mediaFiles(t, totemPath+"/Target/vsco/bob/Gallery"); len(gallery) != 2 || gallery["g1.jpg"] != "image g1" {
+		t.Errorf("gallery after retry = %v", gallery)
+	}
+	if _, err := os.Stat(totemPath + "/Target/vsco/bob/Gallery/.FailedDownloads.json"); !os.IsNotExist(err) {
+		t.Errorf("failed downloads still recorded after retry: %v", err)
+	}
+}
+
+func TestGetUserFinishesInFlightDownloadsWhenInterrupted(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	for k := int64(1); k <= 10; k++ {
+		id := "g" + strconv.FormatInt(k, 10)
+		fake.AddGalleryMedia(site.SiteID, media(id, k), []byte("image "+id))
+	}
+
+	// Interrupted while the first file is being served
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake.OnFile(func(path string) {
+		if strings.HasPrefix(path, "/files/media/") {
+			cancel()
+		}
+	})
+
+	report := getUser(ctx, config, newTrackingProfile("bob"))
+	if len(report.Failures) == 0 || !errors.Is(report.Failures[len(report.Failures)-1].Err, context.Canceled) {
+		t.Errorf("failures = %+v, want the run interrupted", report.Failures)
+	}
+
+	gallery := mediaFiles(t, totemPath+"/Target/vsco/bob/Gallery")
+	if len(gallery) == 0 {
+		t.Error("no downloads finished, want the ones in flight written")
+	}
+
+	// What was left undone is retried next run, without counting as a failed attempt
+	var failures []vscoservice.FailedDownload
+	bytes, err := os.ReadFile(totemPath + "/Target/vsco/bob/Gallery/.FailedDownloads.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(bytes, &failures); err != nil {
+		t.Fatal(err)
+	}
+	if len(gallery)+len(failures) != 10 {
+		t.Errorf("%d written & %d left for next run, want all 10", len(gallery), len(failures))
+	}
+	for _, f := range failures {
+		if f.Attempts != 0 {
+			t.Errorf("failure = %+v, want no attempts counted", f)
+		}
+	}
+}
+
+func TestGetUserStopsRetryingDownloadsWhenInterrupted(t *testing.T) {
+	fake, config := setupFake(t)
+	httpClient = &http.Client{Transport: transport.New(fake.Client().Transport, transport.Config{
+		MaxRetries: 10,
+		BaseDelay:  time.Minute,
+		MaxDelay:   time.Minute,
+	})}
+
+	site := fake.AddSite("bob", "")
+	for k := int64(1); k <= 10; k++ {
+		id := "g" + strconv.FormatInt(k, 10)
+		fake.AddGalleryMedia(site.SiteID, media(id, k), []byte("image "+id))
+	}
+	fake.Fail("/files/media/", http.StatusServiceUnavailable, 100)
+
+	// Interrupted once the first download got its error, while it backs off before retrying
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			for _, r := range fake.Requests() {
+				if strings.HasPrefix(r, "/files/media/") {
+					cancel()
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	getUser(ctx, config, newTrackingProfile("bob"))
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("interrupted run took %s, want the downloads to stop retrying", elapsed)
+	}
+	if n := countRequests(fake, "/files/media/"); n > 3 {
+		t.Errorf("%d media requests, want at most the 3 in flight", n)
+	}
+}
+
+func TestGetUserStopsPagingAtArchivedMedia(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	for i := 1; i <= 65; i++ {
+		id := "g" + strconv.Itoa(i)
+		fake.AddGalleryMedia(site.SiteID, media(id, int64(i)), []byte("image "+id))
+	}
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	fake.AddGalleryMedia(site.SiteID, media("new", 100), []byte("image new"))
+	before := countRequests(fake, "/api/2.0/medias")
+	runGetUser(t, config, tp)
+
+	if pages := countRequests(fake, "/api/2.0/medias") - before; pages != 1 {
+		t.Errorf("incremental run requested %d pages, want 1", pages)
+	}
+	if gallery := mediaFiles(t, totemPath+"/Target/vsco/bob/Gallery"); len(gallery) != 66 || gallery["new.jpg"] != "image new" {
+		t.Errorf("gallery has %d media, new = %q", len(gallery), gallery["new.jpg"])
+	}
+
+	fullScanFlag = true
+	defer func() { fullScanFlag = false }()
+
+	before = countRequests(fake, "/api/2.0/medias")
+	runGetUser(t, config, tp)
+
+	if pages := countRequests(fake, "/api/2.0/medias") - before; pages != 4 {
+		t.Errorf("full run requested %d pages, want 4", pages)
+	}
+}
+
+func TestGetUserRecordsDeletedMedia(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+	fake.AddGalleryMedia(site.SiteID, media("g2", 2), []byte("image g2"))
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	fake.RemoveGalleryMedia(site.SiteID, "g1")
+	fullScanFlag = true
+	defer func() { fullScanFlag = false }()
+	runGetUser(t, config, tp)
+	runGetUser(t, config, tp)
+
+	accountDir := totemPath + "/Target/vsco/bob"
+	events, err := service.ReadHistory(accountDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Kind != service.EventMediaDeleted || events[0].MediaID != "g1" || events[0].LastSeen == 0 {
+		t.Errorf("history = %+v, want g1 deleted once", events)
+	}
+	if gallery := mediaFiles(t, accountDir+"/Gallery"); gallery["g1.jpg"] != "image g1" {
+		t.Errorf("deleted media was removed from the archive: %v", gallery)
+	}
+}
+
+func TestGetUserRecordsMediaEdits(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	edited := media("g1", 1)
+	edited.Description = "edited caption"
+	edited.LastUpdated = 1700000000000
+	fake.UpdateGalleryMedia(site.SiteID, edited)
+	runGetUser(t, config, tp)
+	runGetUser(t, config, tp)
+
+	accountDir := totemPath + "/Target/vsco/bob"
+	mediaDir := accountDir + "/Gallery/" + readMediaIndex(t, accountDir+"/Gallery").Media["g1"].Filename
+
+	var info vscoservice.VSCOMedia
+	var revisions []vscoservice.MediaRevision
+	for file, v := range map[string]interface{}{"/Info.json": &info, "/Revisions.json": &revisions} {
+		bytes, err := os.ReadFile(mediaDir + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(bytes, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if info.Description != "edited caption" {
+		t.Errorf("Info.json description = %q, want edited caption", info.Description)
+	}
+	if len(revisions) != 1 || revisions[0].Media.Description != "caption g1" || strings.Join(revisions[0].ChangedFields, ",") != "description,last_updated" {
+		t.Errorf("revisions = %+v", revisions)
+	}
+	if events, _ := service.ReadHistory(accountDir); len(events) != 1 || events[0].Kind != service.EventMediaEdited {
+		t.Errorf("history = %+v, want one edit", events)
+	}
+}
+
+func TestGetUserRecordsEditsToOlderMediaOnIncrementalRuns(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	for i := 1; i <= 5; i++ {
+		id := "g" + strconv.Itoa(i)
+		fake.AddGalleryMedia(site.SiteID, media(id, int64(i)), []byte("image "+id))
+	}
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+	runGetUser(t, config, tp)
+
+	edited := media("g2", 2)
+	edited.Description = "edited caption"
+	fake.UpdateGalleryMedia(site.SiteID, edited)
+	runGetUser(t, config, tp)
+
+	events, _ := service.ReadHistory(totemPath + "/Target/vsco/bob")
+	if len(events) != 1 || events[0].Kind != service.EventMediaEdited || events[0].MediaID != "g2" {
+		t.Errorf("history = %+v, want g2 edited", events)
+	}
+}
+
+func TestGetUserRecordsProfileImageChanges(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+	runGetUser(t, config, tp)
+
+	fake.SetProfileImage(site.SiteID, "avatar2", []byte("second avatar"))
+	runGetUser(t, config, tp)
+
+	if n := countRequests(fake, "/files/profile/"+site.ProfileImageID); n != 1 {
+		t.Errorf("first profile image downloaded %d times, want 1", n)
+	}
+
+	var records []vscoservice.ProfileImageRecord
+	bytes, err := os.ReadFile(totemPath + "/Target/vsco/bob/Profile/.ProfileImageRecord.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(bytes, &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ImageID != site.ProfileImageID || records[1].ImageID != "avatar2" || records[1].SHA256 == "" {
+		t.Errorf("profile image records = %+v", records)
+	}
+	if events, _ := service.ReadHistory(totemPath + "/Target/vsco/bob"); len(events) != 1 || events[0].Kind != service.EventProfileImageChanged {
+		t.Errorf("history = %+v, want one profile image change", events)
+	}
+}
+
+func TestGetUserTracksAccountStatus(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	tp := newTrackingProfile("bob", "reserved")
+
+	statuses := func(report runReport) []string {
+		var changes []string
+		for _, c := range report.StatusChanges {
+			changes = append(changes, c.Username+":"+formatStatus(c.Change.From)+"->"+c.Change.To)
+		}
+		return changes
+	}
+	run := func(want ...string) {
+		t.Helper()
+		report := getUser(context.Background(), config, tp)
+		if got := statuses(report); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("status changes = %v, want %v", got, want)
+		}
+	}
+
+	// The first lookup sets the statuses without changing them
+	run()
+	if tp.Accounts[0].Status != service.StatusActive || tp.Accounts[1].Status != service.StatusNeverExisted || len(tp.Accounts[0].StatusChanges) != 0 {
+		t.Errorf("first statuses = %s, %s, changes = %+v", tp.Accounts[0].Status, tp.Accounts[1].Status, tp.Accounts[0].StatusChanges)
+	}
+	run()
+
+	// Only runs notice changes, so printing the profile in between leaves them for the run's summary
+	fake.DeleteSite(site.SiteID)
+	getBioInfoForTrackingProfile(context.Background(), config, "Target", []service.TrackingProfile{*tp})
+	getProfileImagesForTrackingProfile(context.Background(), config, "Target", []service.TrackingProfile{*tp})
+	run("bob:active->missing")
+	run()
+
+	// Missing for long enough to be deleted
+	tp.Accounts[0].StatusSince -= service.DeletedAfter
+	run("bob:missing->deleted")
+
+	fake.RestoreSite(site.SiteID)
+	run("bob:deleted->restored")
+	run("bob:restored->active")
+
+	if tp.Accounts[0].Status != service.StatusActive || tp.Accounts[1].Status != service.StatusNeverExisted {
+		t.Errorf("statuses = %s, %s", tp.Accounts[0].Status, tp.Accounts[1].Status)
+	}
+}
+
+func TestGetUserAlertsWhenReservedUsernameIsCreated(t *testing.T) {
+	fake, config := setupFake(t)
+
+	tp := newTrackingProfile("reserved")
+	if alerts := getUser(context.Background(), config, tp).alerts(); len(alerts) != 0 {
+		t.Errorf("alerts before the account exists = %v", alerts)
+	}
+
+	site := fake.AddSite("reserved", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+
+	// Printing the profile only reads what is archived, so the creation is left for the run to notice
+	requests := len(fake.Requests())
+	getBioInfoForTrackingProfile(context.Background(), config, "Target", []service.TrackingProfile{*tp})
+	getProfileImagesForTrackingProfile(context.Background(), config, "Target", []service.TrackingProfile{*tp})
+	if tp.Accounts[0].Status != service.StatusNeverExisted || len(fake.Requests()) != requests {
+		t.Errorf("status after bio & avatars = %s, requests = %d, want never-existed & none", tp.Accounts[0].Status, len(fake.Requests())-requests)
+	}
+
+	report := getUser(context.Background(), config, tp)
+	if alerts := report.alerts(); len(alerts) != 1 || !strings.Contains(alerts[0], "reserved") {
+		t.Errorf("alerts = %v, want one for reserved", alerts)
+	}
+
+	a := tp.Accounts[0]
+	if a.Created == 0 || a.ID(vscoservice.SiteIDKey) != strconv.Itoa(site.SiteID) {
+		t.Errorf("created = %d, ids = %v", a.Created, a.IDs)
+	}
+	if gallery := mediaFiles(t, totemPath+"/Target/vsco/reserved/Gallery"); gallery["g1.jpg"] != "image g1" {
+		t.Errorf("gallery = %v", gallery)
+	}
+	if events, _ := service.ReadHistory(totemPath + "/Target/vsco/reserved"); len(events) != 1 || events[0].Description() != "Account was created" {
+		t.Errorf("history = %+v, want account created", events)
+	}
+}
+
+func TestLegacyReservedUsernameAlertsWhenCreated(t *testing.T) {
+	fake, config := setupFake(t)
+
+	oldTrackingFile := trackingFile
+	trackingFile = totemPath + "/Tracking.yaml"
+	defer func() { trackingFile = oldTrackingFile }()
+
+	legacy := `- targetname: Target
+  active: true
+  accounts:
+    - userid: 67890
+      siteid: 12345
+      username: bob
+    - userid: -1
+      siteid: -1
+      username: reserved
+`
+	if err := os.WriteFile(trackingFile, []byte(legacy), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	trackingProfiles := deserializeTrackingProfiles()
+	tp := &trackingProfiles[0]
+	if tp.Accounts[0].Status != "" || tp.Accounts[1].Status != service.StatusNeverExisted {
+		t.Fatalf("migrated statuses = %q, %q, want none & never-existed", tp.Accounts[0].Status, tp.Accounts[1].Status)
+	}
+
+	// Found on the first run after the upgrade
+	fake.AddSite("reserved", "")
+	report := getUser(context.Background(), config, tp)
+	if alerts := report.alerts(); len(alerts) != 1 || !strings.Contains(alerts[0], "reserved") {
+		t.Errorf("alerts = %v, want one for reserved", alerts)
+	}
+	if a := tp.Accounts[1]; a.Status != service.StatusActive || a.Created == 0 {
+		t.Errorf("reserved = %+v, want active & created", a)
+	}
+}
+
+func TestGetUserKeepsMediaUploadedInTheSameSecond(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+	fake.AddGalleryMedia(site.SiteID, media("g2", 1), []byte("image g2"))
+
+	runGetUser(t, config, newTrackingProfile("bob"))
+
+	if gallery := mediaFiles(t, totemPath+"/Target/vsco/bob/Gallery"); len(gallery) != 2 {
+		t.Errorf("gallery = %v, want both media", gallery)
+	}
+	if _, err := os.Stat(totemPath + "/Target/vsco/bob/Gallery/20200913T122641Z_g1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrateLayoutRenamesOldArchives(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	// Move the media back to the old layout, from before the index existed
+	galleryDir := totemPath + "/Target/vsco/bob/Gallery"
+	oldDir := galleryDir + "/" + time.Unix(1600000001, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+	if err := os.Rename(galleryDir+"/20200913T122641Z_g1", oldDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(galleryDir + "/.Index.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateLayoutForTrackingProfiles(config, nil, "", []service.TrackingProfile{*tp})
+
+	if _, err := os.Stat(oldDir); !os.IsNotExist(err) {
+		t.Errorf("old directory still exists: %v", err)
+	}
+	if bytes, err := os.ReadFile(galleryDir + "/20200913T122641Z_g1/g1.jpg"); err != nil || string(bytes) != "image g1" {
+		t.Errorf("migrated media = %q, %v", bytes, err)
+	}
+	if sum := readMediaIndex(t, galleryDir).Media["g1"].SHA256; sum == "" {
+		t.Errorf("migrated media was not hashed")
+	}
+
+	runGetUser(t, config, tp)
+	if n := countRequests(fake, "/files/media/g1.jpg"); n != 1 {
+		t.Errorf("g1 downloaded %d times, want 1", n)
+	}
+}
+
+// Points the files migrateAccountDirs uses into the test's Totem directory
+func setupAccountDirsMigration(t *testing.T) {
+	oldCatalogFile, oldMigratedFile := catalogFile, accountDirsMigratedFile
+	catalogFile, accountDirsMigratedFile = totemPath+"/.Catalog.db", totemPath+"/.AccountDirsMigrated"
+	t.Cleanup(func() { catalogFile, accountDirsMigratedFile = oldCatalogFile, oldMigratedFile })
+}
+
+func TestMigrateAccountDirsMovesOldArchives(t *testing.T) {
+	fake, config := setupFake(t)
+	setupAccountDirsMigration(t)
+
+	// An account named like the platform's directory
+	for _, username := range []string{"bob", "vsco"} {
+		site := fake.AddSite(username, "")
+		fake.AddGalleryMedia(site.SiteID, media(username+"1", 1), []byte("image "+username))
+	}
+	tp := newTrackingProfile("bob", "vsco")
+	runGetUser(t, config, tp)
+
+	// Move the accounts back to where older versions kept them
+	targetDir := totemPath + "/Target"
+	for _, rename := range [][2]string{
+		{targetDir + "/vsco/bob", targetDir + "/bob"},
+		{targetDir + "/vsco/vsco", targetDir + "/old-vsco"},
+		{targetDir + "/vsco", targetDir + "/empty"},
+		{targetDir + "/old-vsco", targetDir + "/vsco"},
+	} {
+		if err := os.Rename(rename[0], rename[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// As far as the catalog knows, nothing is archived
+	if err := catalogDB.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateAccountDirs(config, []service.TrackingProfile{*tp})
+
+	for _, username := range []string{"bob", "vsco"} {
+		if gallery := mediaFiles(t, targetDir+"/vsco/"+username+"/Gallery"); gallery[username+"1.jpg"] != "image "+username {
+			t.Errorf("%s gallery = %v", username, gallery)
+		}
+	}
+	if _, err := os.Stat(targetDir + "/bob"); !os.IsNotExist(err) {
+		t.Errorf("old bob directory: %v, want it moved", err)
+	}
+
+	media, err := catalogDB.Media("Target")
+	if err != nil || len(media) != 2 {
+		t.Fatalf("catalog media = %+v, %v, want both accounts' media reindexed", media, err)
+	}
+	for _, m := range media {
+		if !strings.HasPrefix(m.Dir, targetDir+"/vsco/"+m.Username+"/Gallery/") {
+			t.Errorf("catalog has %s in %s", m.ID, m.Dir)
+		}
+	}
+
+	// Once everything was moved, later starts skip the migration
+	if err := os.Mkdir(targetDir+"/alice", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(targetDir+"/alice/Gallery", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	migrateAccountDirs(config, []service.TrackingProfile{*newTrackingProfile("alice")})
+	if _, err := os.Stat(targetDir + "/alice/Gallery"); err != nil {
+		t.Errorf("alice after the migration finished: %v, want her left alone", err)
+	}
+	if gallery := mediaFiles(t, targetDir+"/vsco/vsco/Gallery"); len(gallery) != 1 {
+		t.Errorf("vsco gallery after a second migration = %v", gallery)
+	}
+}
+
+func TestMigrateAccountDirsRecoversFailedMoves(t *testing.T) {
+	_, config := setupFake(t)
+	setupAccountDirsMigration(t)
+
+	targetDir := totemPath + "/Target"
+	for _, dir := range []string{targetDir + "/bob/Gallery", targetDir + "/.migrating-carol/Gallery"} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The platform's directory cannot be created, so nothing can be moved into place
+	if err := os.WriteFile(targetDir+"/vsco", nil, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	tp := newTrackingProfile("bob", "carol")
+	migrateAccountDirs(config, []service.TrackingProfile{*tp})
+	migrateAccountDirs(config, []service.TrackingProfile{*newTrackingProfile("bob")})
+
+	for _, dir := range []string{targetDir + "/bob/Gallery", targetDir + "/carol/Gallery"} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("%s: %v, want it moved back", dir, err)
+		}
+	}
+	if _, err := os.Stat(accountDirsMigratedFile); !os.IsNotExist(err) {
+		t.Errorf("migration recorded as finished: %v", err)
+	}
+
+	// Retried on the next start
+	if err := os.Remove(targetDir + "/vsco"); err != nil {
+		t.Fatal(err)
+	}
+	migrateAccountDirs(config, []service.TrackingProfile{*tp})
+	for _, username := range []string{"bob", "carol"} {
+		if _, err := os.Stat(targetDir + "/vsco/" + username + "/Gallery"); err != nil {
+			t.Errorf("%s: %v, want it moved", username, err)
+		}
+	}
+	if leftovers, _ := filepath.Glob(targetDir + "/.migrating-*"); len(leftovers) != 0 {
+		t.Errorf("leftovers = %v", leftovers)
+	}
+	if _, err := os.Stat(accountDirsMigratedFile); err != nil {
+		t.Errorf("migration not recorded as finished: %v", err)
+	}
+}
+
+func TestRepairCollectionRekeysByCollectedTime(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	fake.AddCollectionMedia(site.SiteID, vscoservice.VSCOMedia{ID: "c1", UploadDate: 1500000000000, CollectedDate: 1600000005000}, []byte("image c1"))
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	collectionDir := totemPath + "/Target/vsco/bob/Collection"
+	index := readMediaIndex(t, collectionDir)
+	if c1 := index.Media["c1"]; c1.Filename != "20200913T122645Z_c1" || c1.Uploaded != 1500000000000 || c1.Collected != 1600000005000 {
+		t.Fatalf("indexed c1 = %+v", c1)
+	}
+
+	// Move the media back to where older versions stored it, named after upload time + collected time
+	oldDir := collectionDir + "/" + time.Unix(3100000005, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+	if err := os.Rename(collectionDir+"/20200913T122645Z_c1", oldDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(collectionDir + "/.Index.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateLayoutForTrackingProfiles(config, []string{"Target"}, "Collection", []service.TrackingProfile{*tp})
+
+	index = readMediaIndex(t, collectionDir)
+	if c1 := index.Media["c1"]; c1.Filename != "20200913T122645Z_c1" || c1.Time != 1600000005000 {
+		t.Errorf("indexed c1 = %+v, want it keyed on its collected time", c1)
+	}
+	if _, err := os.Stat(collectionDir + "/20200913T122645Z_c1/c1.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	runGetUser(t, config, tp)
+	if n := countRequests(fake, "/files/media/c1.jpg"); n != 1 {
+		t.Errorf("c1 downloaded %d times, want 1", n)
+	}
+}
+
+func TestGetUserSharesIdenticalMediaBetweenTargets(t *testing.T) {
+	fake, config := setupFake(t)
+
+	bob := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(bob.SiteID, media("g1", 1), []byte("shared image"))
+	alice := fake.AddSite("alice", "")
+	fake.AddCollectionMedia(alice.SiteID, vscoservice.VSCOMedia{ID: "c1", UploadDate: 1600000001000, CollectedDate: 1600000002000}, []byte("shared image"))
+
+	target := newTrackingProfile("bob")
+	other := newTrackingProfile("alice")
+	other.TargetName = "Other"
+	runGetUser(t, config, target)
+	runGetUser(t, config, other)
+
+	galleryFile, err := os.Stat(totemPath + "/Target/vsco/bob/Gallery/20200913T122641Z_g1/g1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectionFile, err := os.Stat(totemPath + "/Other/vsco/alice/Collection/20200913T122642Z_c1/c1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(galleryFile, collectionFile) {
+		t.Errorf("identical media is stored twice")
+	}
+
+	duplicates := findDuplicates(config, []string{"Target"}, []service.TrackingProfile{*target, *other})
+	if len(duplicates) != 1 || len(duplicates[0].Copies) != 2 || duplicates[0].targets() != 2 {
+		t.Fatalf("duplicates = %+v, want g1 & c1", duplicates)
+	}
+	if _, err := os.Stat(totemPath + "/.Blobs/" + duplicates[0].SHA256[:2] + "/" + duplicates[0].SHA256); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetUserKeepsCatalogInSync(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "first bio")
+	located := media("g1", 1)
+	located.HasLocation = true
+	located.LocationCoordinates = []float64{-73.98, 40.75}
+	located.ImageMetadata.Make, located.ImageMetadata.Model = "FUJIFILM", "X100V"
+	fake.AddGalleryMedia(site.SiteID, located, []byte("image g1"))
+	fake.AddGalleryMedia(site.SiteID, media("g2", 2), []byte("image g2"))
+
+	tp := newTrackingProfile("bob")
+	runGetUser(t, config, tp)
+
+	fake.SetBio(site.SiteID, "second bio")
+	fake.RemoveGalleryMedia(site.SiteID, "g2")
+	fullScanFlag = true
+	defer func() { fullScanFlag = false }()
+	runGetUser(t, config, tp)
+
+	check := func(when string) {
+		t.Helper()
+
+		media, err := catalogDB.Media("Target")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(media) != 2 || media[0].ID != "g1" || media[1].ID != "g2" {
+			t.Fatalf("%s: media = %+v", when, media)
+		}
+		if g1 := media[0]; !g1.HasLocation || g1.Latitude != 40.75 || g1.Longitude != -73.98 || g1.Model != "X100V" || g1.SHA256 == "" {
+			t.Errorf("%s: g1 = %+v", when, g1)
+		}
+		if media[1].Deleted == 0 {
+			t.Errorf("%s: g2 is not marked deleted", when)
+		}
+
+		bios, err := catalogDB.Bios("Target")
+		if err != nil || len(bios) != 2 || bios[1].Description != "second bio" {
+			t.Errorf("%s: bios = %+v, %v", when, bios, err)
+		}
+		sites, err := catalogDB.Sites("Target")
+		if err != nil || len(sites) != 1 || sites[0].Description != "second bio" {
+			t.Errorf("%s: sites = %+v, %v", when, sites, err)
+		}
+		events, err := catalogDB.Events("Target")
+		if err != nil || len(events) != 1 || events[0].Kind != service.EventMediaDeleted {
+			t.Errorf("%s: events = %+v, %v", when, events, err)
+		}
+		targets, err := catalogDB.Targets()
+		if err != nil || len(targets) != 1 || targets[0].Name != "Target" {
+			t.Errorf("%s: targets = %+v, %v", when, targets, err)
+		}
+	}
+
+	check("after run")
+
+	if err := catalogDB.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	reindexTrackingProfiles(config, nil, []service.TrackingProfile{*tp})
+	check("after reindex")
+}
+
+func TestSearchFiltersCatalog(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "likes sunsets")
+	tagged := media("g1", 1)
+	tagged.Description = "Sunset at the pier"
+	if err := json.Unmarshal([]byte(`[{"text":"Sunset","slug":"sunset"}]`), &tagged.Tags); err != nil {
+		t.Fatal(err)
+	}
+	tagged.ImageMetadata.Make, tagged.ImageMetadata.Model = "Apple", "iPhone 12"
+	tagged.HasLocation = true
+	tagged.LocationCoordinates = []float64{-73.98, 40.75}
+	fake.AddGalleryMedia(site.SiteID, tagged, []byte("image g1"))
+	video := media("g2", 86400*30)
+	video.IsVideo = true
+	fake.AddGalleryMedia(site.SiteID, video, []byte("video g2"))
+
+	runGetUser(t, config, newTrackingProfile("bob"))
+
+	yes := true
+	to, err := parseSearchDate("2020-09-14", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		query catalog.Query
+		media []string
+		bios  int
+	}{
+		{catalog.Query{Text: "SUNSET"}, []string{"g1"}, 1},
+		{catalog.Query{Tag: "sunset"}, []string{"g1"}, 0},
+		{catalog.Query{Model: "iphone"}, []string{"g1"}, 0},
+		{catalog.Query{HasLocation: &yes}, []string{"g1"}, 0},
+		{catalog.Query{MediaType: "video"}, []string{"g2"}, 0},
+		{catalog.Query{Target: "Target", Account: "bob"}, []string{"g1", "g2"}, 1},
+		{catalog.Query{To: to}, []string{"g1"}, 0},
+		{catalog.Query{Account: "alice"}, nil, 0},
+	} {
+		media, err := catalogDB.SearchMedia(c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, m := range media {
+			ids = append(ids, m.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(c.media, ",") {
+			t.Errorf("%+v: media = %v, want %v", c.query, ids, c.media)
+		}
+
+		bios, err := catalogDB.SearchBios(c.query)
+		if err != nil || len(bios) != c.bios {
+			t.Errorf("%+v: bios = %+v, %v, want %d", c.query, bios, err, c.bios)
+		}
+	}
+}
+
+func TestCatalogCanBeReadWhileARunHasItOpen(t *testing.T) {
+	fake, config := setupFake(t)
+
+	if _, err := catalog.OpenReadOnly(totemPath + "/Missing.db"); err == nil {
+		t.Error("read-only catalog that does not exist: no error")
+	}
+
+	site := fake.AddSite("bob", "")
+	fake.AddGalleryMedia(site.SiteID, media("g1", 1), []byte("image g1"))
+	runGetUser(t, config, newTrackingProfile("bob"))
+
+	// catalogDB is still open for writing, as it is for the rest of a run
+	reader, err := catalog.OpenReadOnly(totemPath + "/.Catalog.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if media, err := reader.SearchMedia(catalog.Query{Target: "Target"}); err != nil || len(media) != 1 {
+		t.Errorf("media = %+v, %v, want g1", media, err)
+	}
+	if err := reader.PutTarget(catalog.Target{Name: "Other"}); err == nil {
+		t.Error("write to a read-only catalog: no error")
+	}
+	if err := catalogDB.PutTarget(catalog.Target{Name: "Other"}); err != nil {
+		t.Errorf("write while a reader has the catalog open: %v", err)
+	}
+}
+
+func TestExportGeoWritesGeotaggedPosts(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	located := media("g1", 1)
+	located.HasLocation = true
+	located.LocationCoordinates = []float64{-73.98, 40.75}
+	fake.AddGalleryMedia(site.SiteID, located, []byte("image g1"))
+	fake.AddGalleryMedia(site.SiteID, media("g2", 2), []byte("image g2"))
+	collected := vscoservice.VSCOMedia{ID: "c1", UploadDate: 1600000001000, CollectedDate: 1600000002000, HasLocation: true, LocationCoordinates: []float64{2.35, 48.85}}
+	fake.AddCollectionMedia(site.SiteID, collected, []byte("image c1"))
+
+	runGetUser(t, config, newTrackingProfile("bob"))
+
+	outDir := totemPath + "/Exports"
+	if err := exportGeo("Target", outDir, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var collection geoJSONFeatureCollection
+	bytes, err := os.ReadFile(outDir + "/Target.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(bytes, &collection); err != nil {
+		t.Fatal(err)
+	}
+	if len(collection.Features) != 1 {
+		t.Fatalf("features = %+v, want g1", collection.Features)
+	}
+	if f := collection.Features[0]; f.Geometry.Coordinates != [2]float64{-73.98, 40.75} || f.Properties.Account != "bob" || f.Properties.Timestamp != "2020-09-13T12:26:41Z" || !strings.HasSuffix(f.Properties.Path, "/g1.jpg") {
+		t.Errorf("feature = %+v", f)
+	}
+
+	var kml kmlDocument
+	bytes, err = os.ReadFile(outDir + "/Target.kml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(bytes, &kml); err != nil {
+		t.Fatal(err)
+	}
+	if len(kml.Placemarks) != 1 || kml.Placemarks[0].Coordinates != "-73.98,40.75" {
+		t.Errorf("placemarks = %+v", kml.Placemarks)
+	}
+}
+
+func TestFindPlacesClustersAndLabelsPosts(t *testing.T) {
+	post := func(lat, lon float64, unix int64) catalog.Media {
+		return catalog.Media{Uploaded: unix * 1000, HasLocation: true, Latitude: lat, Longitude: lon}
+	}
+
+	var posts []catalog.Media
+	for day := int64(0); day < 3; day++ {
+		// Monday, Sep 14 2020 onwards at 14h & 23h UTC
+		posts = append(posts, post(40.7600+float64(day)*0.0005, -73.9700, 1600092000+day*86400))
+		posts = append(posts, post(40.7500, -73.9800+float64(day)*0.0005, 1600124400+day*86400))
+	}
+	posts = append(posts, post(48.85, 2.35, 1600200000))
+
+	places := findPlaces(posts, 250, 3, time.UTC)
+	if len(places) != 2 {
+		t.Fatalf("places = %+v, want home & work", places)
+	}
+	for _, p := range places {
+		if len(p.Posts) != 3 || p.Visits != 3 || p.Weekdays != 3 {
+			t.Errorf("place = %+v, want 3 weekday posts on 3 days", p)
+		}
+	}
+
+	labels := map[string]float64{places[0].Label: places[0].Latitude, places[1].Label: places[1].Latitude}
+	if lat, ok := labels["home"]; !ok || math.Abs(lat-40.75) > 0.001 {
+		t.Errorf("labels = %v, want home at 40.75", labels)
+	}
+	if lat, ok := labels["work"]; !ok || math.Abs(lat-40.7605) > 0.001 {
+		t.Errorf("labels = %v, want work at 40.7605", labels)
+	}
+
+	// For someone in UTC+9 the 14h UTC posts are the late evening ones
+	places = findPlaces(posts, 250, 3, time.FixedZone("", 9*3600))
+	for _, p := range places {
+		if p.Label == "home" && math.Abs(p.Latitude-40.7605) > 0.001 {
+			t.Errorf("home in UTC+9 = %+v, want at 40.7605", p)
+		}
+	}
+
+	setupFake(t)
+	if err := printPlaces("Target", 250, 3, "+25:00"); err == nil {
+		t.Error("invalid --offset: no error")
+	}
+}
+
+func TestActivityInfersUTCOffsetAndDrawsHeatmap(t *testing.T) {
+	// Someone in UTC-5 posting between 8h & 23h local time for a week
+	var times []time.Time
+	var posts []catalog.Media
+	for day := 0; day < 7; day++ {
+		for hour := 8; hour < 24; hour += 3 {
+			local := time.Date(2021, time.March, 1+day, hour, 0, 0, 0, time.FixedZone("", -5*3600))
+			times = append(times, local)
+			posts = append(posts, catalog.Media{Captured: local.Add(-time.Hour).UnixNano() / int64(time.Millisecond), Uploaded: local.UnixNano() / int64(time.Millisecond)})
+		}
+	}
+
+	offset, ok := inferUTCOffset(times)
+	if !ok || offset != -5*time.Hour {
+		t.Errorf("offset = %v, %v, want -5h", offset, ok)
+	}
+	if _, ok := inferUTCOffset(times[:minPostsForOffset-1]); ok {
+		t.Errorf("offset inferred from too few posts")
+	}
+	if offset, err := parseUTCOffset("+5:30"); err != nil || offset != 5*time.Hour+30*time.Minute {
+		t.Errorf("parsed offset = %v, %v", offset, err)
+	}
+
+	h := buildHeatmap(times, time.FixedZone("", -5*3600))
+	if h[time.Monday][8] != 1 || h[time.Monday][9] != 0 || h[time.Sunday][23] != 1 {
+		t.Errorf("heatmap = %v", h)
+	}
+
+	if gaps := captureGaps(posts); len(gaps) != len(posts) || gaps[0] != time.Hour {
+		t.Errorf("gaps = %v, want an hour for each post", gaps)
+	}
+
+	dir := t.TempDir()
+	if err := writeHeatmapPNG(dir+"/activity.png", h); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dir + "/activity.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := png.Decode(f); err != nil {
+		t.Error(err)
+	}
+
+	if err := writeHeatmapSVG(dir+"/activity.svg", h, "Target & co"); err != nil {
+		t.Fatal(err)
+	}
+	var svg struct {
+		Rects []struct{} `xml:"rect"`
+	}
+	bytes, err := os.ReadFile(dir + "/activity.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(bytes, &svg); err != nil || len(svg.Rects) != 7*24 {
+		t.Errorf("svg has %d cells, %v", len(svg.Rects), err)
+	}
+}
+
+func TestDevicesFlagsUncommonDevicesSharedBetweenTargets(t *testing.T) {
+	post := func(target, username, maker, model string, uploadSecond int64) catalog.Media {
+		return catalog.Media{
+			AccountRef: catalog.AccountRef{Target: target, Platform: vscoservice.Platform, Username: username},
+			Uploaded:   (1600000000 + uploadSecond) * 1000,
+			Make:       maker,
+			Model:      model,
+		}
+	}
+
+	uses := deviceUses([]catalog.Media{
+		post("Target", "bob", "FUJIFILM", "X100V", 1),
+		post("Target", "bob", "fujifilm", "x100v", 5),
+		post("Target", "bob", "Apple", "iPhone 12", 2),
+		post("Other", "alice", "FUJIFILM", "X100V", 3),
+		post("Other", "alice", "Apple", "iPhone 12", 4),
+		post("Third", "carol", "Apple", "iPhone 12", 6),
+		post("Third", "carol", "", "", 7),
+		post("Target", "bob", "LGE", "LM-G900", 8),
+		post("Third", "carol", "LGE", "LM-G900", 9),
+	})
+
+	if len(uses) != 7 {
+		t.Fatalf("uses = %+v, want 7 account & device pairs", uses)
+	}
+	for _, u := range uses {
+		if u.Account.Username == "bob" && u.Device == "fujifilm x100v" && (u.Posts != 2 || u.Name != "FUJIFILM X100V" || u.Last.Sub(u.First) != 4*time.Second) {
+			t.Errorf("bob's X100V = %+v, want 2 posts 4s apart", u)
+		}
+	}
+
+	// The iPhone & the LG are mass-market phones, by their make, so sharing them does not count however few accounts use them
+	shared := sharedDevices(uses, 10)
+	if len(shared) != 1 || shared[0].Name != "FUJIFILM X100V" || len(shared[0].Uses) != 2 {
+		t.Errorf("shared = %+v, want only the X100V", shared)
+	}
+
+	// The file's own metadata names the camera when the platform does not, & wins when both do
+	withFile := func(m catalog.Media, maker, model string) catalog.Media {
+		m.FileMetadata = &catalog.FileMetadata{Make: maker, Model: model}
+		return m
+	}
+	media := []catalog.Media{
+		withFile(post("Target", "bob", "", "", 1), "RICOH", "RICOH GR III"),
+		withFile(post("Other", "alice", "Apple", "iPhone 12", 2), "RICOH", "RICOH GR III"),
+		post("Other", "alice", "FUJIFILM", "X100V", 3),
+		post("Third", "carol", "FUJIFILM", "X100V", 4),
+	}
+	shared = sharedDevices(deviceUses(media), 10)
+	if len(shared) != 2 || shared[0].Name != "RICOH GR III" || len(shared[0].Uses) != 2 {
+		t.Errorf("shared = %+v, want the GR III & the X100V", shared)
+	}
+
+	// Once there are enough accounts, a device many of them use is common too
+	for k := 0; k < minAccountsForShare; k++ {
+		media = append(media, post("Crowd", "user"+strconv.Itoa(k), "FUJIFILM", "X100V", int64(10+k)))
+	}
+	shared = sharedDevices(deviceUses(media), 10)
+	if len(shared) != 1 || shared[0].Name != "RICOH GR III" {
+		t.Errorf("shared = %+v, want only the GR III, the X100V is used by most accounts", shared)
+	}
+	if name := deviceName("Canon", "Canon EOS R5"); name != "Canon EOS R5" {
+		t.Errorf("device name = %q", name)
+	}
+}
+
+// Resolved before any test, as runs change the working directory
+var mediametaTestdataDir, _ = filepath.Abs("mediameta/testdata")
+
+// A media file from mediameta's testdata
+func mediametaTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(mediametaTestdataDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestGetUserExtractsFileMetadata(t *testing.T) {
+	fake, config := setupFake(t)
+
+	site := fake.AddSite("bob", "")
+	captured := time.Date(2021, 6, 1, 16, 30, 0, 0, time.UTC)
+
+	// VSCO shows neither the camera nor the location the photo was taken with
+	photo := media("g1", 1)
+	photo.CaptureDate = captured.UnixNano() / int64(time.Millisecond)
+	photo.ImageMetadata.Make, photo.ImageMetadata.Model = "Apple", "iPhone 12"
+	fake.AddGalleryMedia(site.SiteID, photo, mediametaTestdata(t, "canon_eos_r5.jpg"))
+
+	video := media("g2", 2)
+	video.IsVideo = true
+	video.CaptureDate = captured.Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	video.ImageMetadata.Make, video.ImageMetadata.Model = "Apple", "iPhone 12"
+	video.HasLocation = true
+	video.LocationCoordinates = []float64{-73.98, 40.75}
+	fake.AddGalleryMedia(site.SiteID, video, mediametaTestdata(t, "iphone_12.mp4"))
+
+	runGetUser(t, config, newTrackingProfile("bob"))
+
+	readMetadata := func(dir string) vscoservice.MediaMetadata {
+		t.Helper()
+		var mm vscoservice.MediaMetadata
+		bytes, err := os.ReadFile(totemPath + "/Target/vsco/bob/Gallery/" + dir + "/Metadata.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(bytes, &mm); err != nil {
+			t.Fatal(err)
+		}
+		return mm
+	}
+
+	mm := readMetadata("20200913T122641Z_g1")
+	if mm.Format != "jpeg" || mm.Make != "Canon" || mm.Model != "Canon EOS R5" || mm.Width != 3 || mm.Height != 2 || mm.Error != "" {
+		t.Errorf("photo metadata = %+v", mm)
+	}
+	if mm.Captured != photo.CaptureDate || mm.CapturedOffset != "+02:00" {
+		t.Errorf("photo captured = %d %s, want %d +02:00", mm.Captured, mm.CapturedOffset, photo.CaptureDate)
+	}
+	if !mm.HasLocation || math.Abs(mm.Latitude-40.75) > 1e-6 || math.Abs(mm.Longitude+73.98) > 1e-6 {
+		t.Errorf("photo location = %v %f, %f, want 40.75, -73.98", mm.HasLocation, mm.Latitude, mm.Longitude)
+	}
+
+	mm = readMetadata("20200913T122642Z_g2")
+	if mm.Format != "mp4" || mm.Make != "Apple" || mm.Model != "iPhone 12" || mm.Captured != video.CaptureDate || !mm.HasLocation || mm.Error != "" {
+		t.Errorf("video metadata = %+v", mm)
+	}
+
+	// Only the photo disagrees with VSCO, on its make, model & location
+	media, err := catalogDB.SearchMedia(catalog.Query{Discrepancies: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media) != 1 || media[0].ID != "g1" || len(media[0].Discrepancies) != 3 {
+		t.Fatalf("media with discrepancies = %+v, want g1 with 3", media)
+	}
+	if !strings.Contains(media[0].Discrepancies[2], "location") {
+		t.Errorf("discrepancies = %q, want the hidden location", media[0].Discrepancies)
+	}
+
+	// The file's camera is searchable too
+	media, err = catalogDB.SearchMedia(catalog.Query{Model: "eos r5"})
+	if err != nil || len(media) != 1 || media[0].ID != "g1" || media[0].FileMetadata == nil {
+		t.Errorf("media taken with an EOS R5 = %+v, %v, want g1", media, err)
+	}
+}
@@ -0,0 +1,332 @@
+package mediameta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+	errTruncated = errors.New("mediameta: truncated metadata")
+)
+
+// Reads the JPEG's segments up to the image data: APP1 holds EXIF or XMP, SOFn the image size
+func extractJPEG(data []byte) (Metadata, error) {
+	m := Metadata{Format: "jpeg"}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return m, errors.New("mediameta: invalid JPEG segment")
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Padding
+			i++
+			continue
+		}
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			// Segments without a length
+			i += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Image data, no metadata after it
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return m, errTruncated
+		}
+		segment := data[i+4 : i+2+length]
+		i += 2 + length
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(segment, exifHeader):
+			if err := readTIFF(segment[len(exifHeader):], &m); err != nil {
+				return m, err
+			}
+		case marker == 0xE1 && bytes.HasPrefix(segment, xmpHeader):
+			m.XMP = string(segment[len(xmpHeader):])
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			if len(segment) >= 5 {
+				m.Height = int(binary.BigEndian.Uint16(segment[1:]))
+				m.Width = int(binary.BigEndian.Uint16(segment[3:]))
+			}
+		}
+	}
+
+	readXMP(&m)
+	return m, nil
+}
+
+// EXIF tags that are read
+const (
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagSoftware           = 0x0131
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+// Sizes of the EXIF value types, by type
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+type tiffEntry struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// Reads the IFD at offset, by tag
+func (t tiff) ifd(offset uint32) (map[uint16]tiffEntry, error) {
+	if int(offset)+2 > len(t.data) {
+		return nil, errTruncated
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	if int(offset)+2+count*12 > len(t.data) {
+		return nil, errTruncated
+	}
+
+	entries := make(map[uint16]tiffEntry, count)
+	for k := 0; k < count; k++ {
+		e := t.data[int(offset)+2+k*12:]
+		tag, typ, n := t.order.Uint16(e), t.order.Uint16(e[2:]), int(t.order.Uint32(e[4:]))
+
+		// Entries without a value are of no use
+		size, ok := tiffTypeSizes[typ]
+		if !ok || n <= 0 || n > len(t.data) {
+			continue
+		}
+		value := e[8:12]
+		if size*n > 4 {
+			start := int(t.order.Uint32(e[8:]))
+			if start < 0 || start+size*n > len(t.data) {
+				continue
+			}
+			value = t.data[start : start+size*n]
+		}
+		entries[tag] = tiffEntry{typ, n, value[:size*n]}
+	}
+	return entries, nil
+}
+
+func (t tiff) string(e tiffEntry) string {
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// The entry's first value if it is a SHORT or LONG, otherwise 0
+func (t tiff) uint(e tiffEntry) uint32 {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(t.order.Uint16(e.value))
+	case (e.typ == 4 || e.typ == 9) && len(e.value) >= 4:
+		return t.order.Uint32(e.value)
+	}
+	return 0
+}
+
+// Degrees, minutes & seconds as 3 rationals
+func (t tiff) degrees(e tiffEntry) (float64, bool) {
+	if e.typ != 5 || e.count != 3 || len(e.value) < 24 {
+		return 0, false
+	}
+	var parts [3]float64
+	for k := range parts {
+		num, den := t.order.Uint32(e.value[k*8:]), t.order.Uint32(e.value[k*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		parts[k] = float64(num) / float64(den)
+	}
+	return parts[0] + parts[1]/60 + parts[2]/3600, true
+}
+
+func readTIFF(data []byte, m *Metadata) error {
+	if len(data) < 8 {
+		return errTruncated
+	}
+	t := tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return errors.New("mediameta: invalid EXIF byte order")
+	}
+
+	ifd0, err := t.ifd(t.order.Uint32(data[4:]))
+	if err != nil {
+		return err
+	}
+	if e, ok := ifd0[tagMake]; ok {
+		m.Make = t.string(e)
+	}
+	if e, ok := ifd0[tagModel]; ok {
+		m.Model = t.string(e)
+	}
+	if e, ok := ifd0[tagSoftware]; ok {
+		m.Software = t.string(e)
+	}
+	if e, ok := ifd0[tagOrientation]; ok {
+		m.Orientation = int(t.uint(e))
+	}
+
+	captured := ""
+	if e, ok := ifd0[tagDateTime]; ok {
+		captured = t.string(e)
+	}
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if exif, err := t.ifd(t.uint(e)); err == nil {
+			if e, ok := exif[tagDateTimeOriginal]; ok {
+				captured = t.string(e)
+			}
+			if e, ok := exif[tagOffsetTimeOriginal]; ok {
+				m.CapturedOffset = t.string(e)
+			}
+		}
+	}
+	m.Captured = parseEXIFTime(captured, m.CapturedOffset)
+	if m.Captured == 0 {
+		m.CapturedOffset = ""
+	}
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := t.ifd(t.uint(e)); err == nil {
+			lat, latOK := t.degrees(gps[tagGPSLatitude])
+			lon, lonOK := t.degrees(gps[tagGPSLongitude])
+			if latOK && lonOK {
+				if t.string(gps[tagGPSLatitudeRef]) == "S" {
+					lat = -lat
+				}
+				if t.string(gps[tagGPSLongitudeRef]) == "W" {
+					lon = -lon
+				}
+				m.HasLocation, m.Latitude, m.Longitude = true, lat, lon
+			}
+		}
+	}
+	return nil
+}
+
+// Parses an EXIF time, e.g. 2021:06:01 18:30:00, in offset, e.g. +02:00, or in UTC if offset is empty.
+// Returns milliseconds, or 0 if it cannot be parsed.
+func parseEXIFTime(s, offset string) int64 {
+	layout := "2006:01:02 15:04:05"
+	if offset != "" {
+		s += offset
+		layout += "-07:00"
+	}
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Fills in what EXIF did not have from the XMP packet's attributes or elements
+func readXMP(m *Metadata) {
+	if m.XMP == "" {
+		return
+	}
+
+	value := func(name string) string {
+		if i := strings.Index(m.XMP, name+`="`); i >= 0 {
+			rest := m.XMP[i+len(name)+2:]
+			if j := strings.IndexByte(rest, '"'); j >= 0 {
+				return rest[:j]
+			}
+		}
+		if i := strings.Index(m.XMP, "<"+name+">"); i >= 0 {
+			rest := m.XMP[i+len(name)+2:]
+			if j := strings.Index(rest, "</"+name+">"); j >= 0 {
+				return strings.TrimSpace(rest[:j])
+			}
+		}
+		return ""
+	}
+
+	if m.Make == "" {
+		m.Make = value("tiff:Make")
+	}
+	if m.Model == "" {
+		m.Model = value("tiff:Model")
+	}
+	if m.Software == "" {
+		m.Software = value("xmp:CreatorTool")
+	}
+	if m.Captured == 0 {
+		for _, name := range []string{"exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate"} {
+			if t, offset, ok := parseXMPTime(value(name)); ok {
+				m.Captured, m.CapturedOffset = t, offset
+				break
+			}
+		}
+	}
+	if !m.HasLocation {
+		lat, latOK := parseXMPCoordinate(value("exif:GPSLatitude"))
+		lon, lonOK := parseXMPCoordinate(value("exif:GPSLongitude"))
+		if latOK && lonOK {
+			m.HasLocation, m.Latitude, m.Longitude = true, lat, lon
+		}
+	}
+}
+
+// Parses an XMP date, e.g. 2021-06-01T18:30:00+02:00, returning milliseconds & its offset
+func parseXMPTime(s string) (int64, string, bool) {
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999Z07:00", "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UnixNano() / int64(time.Millisecond), t.Format("-07:00"), true
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UnixNano() / int64(time.Millisecond), "", true
+		}
+	}
+	return 0, "", false
+}
+
+// Parses an XMP GPS coordinate, e.g. 40,45.0N
+func parseXMPCoordinate(s string) (float64, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	ref := s[len(s)-1]
+	parts := strings.Split(s[:len(s)-1], ",")
+
+	var degrees float64
+	for k, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || k > 2 {
+			return 0, false
+		}
+		degrees += f / math.Pow(60, float64(k))
+	}
+	if ref == 'S' || ref == 'W' {
+		degrees = -degrees
+	}
+	return degrees, true
+}
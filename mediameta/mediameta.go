@@ -0,0 +1,118 @@
+// Package mediameta reads the metadata embedded in downloaded media files:
+// EXIF & XMP from JPEGs, & creation times, devices & locations from MP4s.
+package mediameta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// What a media file says about itself. Fields the file does not have are left empty.
+type Metadata struct {
+	// "jpeg" or "mp4"
+	Format string `json:"format"`
+
+	Make     string `json:"make,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Software string `json:"software,omitempty"`
+
+	// When the media was captured in milliseconds. If CapturedOffset is empty the file did not say which timezone
+	// the time was in & it was read as UTC.
+	Captured       int64  `json:"captured,omitempty"`
+	CapturedOffset string `json:"captured_offset,omitempty"`
+
+	HasLocation bool    `json:"has_location,omitempty"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+
+	Width       int `json:"width,omitempty"`
+	Height      int `json:"height,omitempty"`
+	Orientation int `json:"orientation,omitempty"`
+
+	// The XMP packet of a JPEG, as is
+	XMP string `json:"xmp,omitempty"`
+}
+
+var ErrUnknownFormat = errors.New("mediameta: not a JPEG or MP4")
+
+// Reads the metadata of a JPEG or MP4 file
+func Extract(data []byte) (Metadata, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return extractJPEG(data)
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return extractMP4(data)
+	}
+	return Metadata{}, ErrUnknownFormat
+}
+
+// What the platform said about the media, to compare with the file's metadata
+type Expected struct {
+	Make  string
+	Model string
+
+	// In milliseconds, 0 if unknown
+	Captured int64
+
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+}
+
+// How far apart locations can be before they are a discrepancy, in metres
+const locationTolerance = 1000
+
+// Describes where m disagrees with what the platform said about the media.
+// Things only one side knows about are not discrepancies, except for the location,
+// as a file with a location the platform hides is worth knowing about.
+func (m Metadata) Discrepancies(e Expected) []string {
+	var found []string
+
+	if m.Make != "" && e.Make != "" && !strings.EqualFold(strings.TrimSpace(m.Make), strings.TrimSpace(e.Make)) {
+		found = append(found, fmt.Sprintf("make is %q in the file but %q on the platform", m.Make, e.Make))
+	}
+	if m.Model != "" && e.Model != "" && !strings.EqualFold(strings.TrimSpace(m.Model), strings.TrimSpace(e.Model)) {
+		found = append(found, fmt.Sprintf("model is %q in the file but %q on the platform", m.Model, e.Model))
+	}
+
+	// Without an offset the file's time could be in any timezone
+	if m.Captured != 0 && e.Captured != 0 {
+		tolerance := time.Minute
+		if m.CapturedOffset == "" {
+			tolerance += 14 * time.Hour
+		}
+		diff := time.Duration(m.Captured-e.Captured) * time.Millisecond
+		if diff > tolerance || diff < -tolerance {
+			found = append(found, fmt.Sprintf("captured %s in the file but %s on the platform", formatMillis(m.Captured), formatMillis(e.Captured)))
+		}
+	}
+
+	switch {
+	case m.HasLocation && !e.HasLocation:
+		found = append(found, fmt.Sprintf("the file has a location, %.5f, %.5f, that the platform does not show", m.Latitude, m.Longitude))
+	case m.HasLocation && e.HasLocation:
+		if d := Distance(m.Latitude, m.Longitude, e.Latitude, e.Longitude); d > locationTolerance {
+			found = append(found, fmt.Sprintf("the file's location, %.5f, %.5f, is %.1fkm from the platform's", m.Latitude, m.Longitude, d/1000))
+		}
+	}
+	return found
+}
+
+func formatMillis(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+const earthRadius = 6371000 // Metres
+
+// Distance between two coordinates in metres, along the Earth's surface
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,252 @@
+package mediameta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+type entry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// A little-endian EXIF IFD at offset, followed by the values too large for their entries
+func ifd(offset int, entries ...entry) []byte {
+	b := make([]byte, 2+len(entries)*12+4)
+	binary.LittleEndian.PutUint16(b, uint16(len(entries)))
+	var values []byte
+	for i, e := range entries {
+		p := b[2+i*12:]
+		binary.LittleEndian.PutUint16(p, e.tag)
+		binary.LittleEndian.PutUint16(p[2:], e.typ)
+		binary.LittleEndian.PutUint32(p[4:], e.count)
+		if len(e.value) <= 4 {
+			copy(p[8:], e.value)
+		} else {
+			binary.LittleEndian.PutUint32(p[8:], uint32(offset+len(b)+len(values)))
+			values = append(values, e.value...)
+		}
+	}
+	return append(b, values...)
+}
+
+func ascii(tag uint16, s string) entry {
+	return entry{tag, 2, uint32(len(s) + 1), []byte(s + "\x00")}
+}
+
+// A JPEG whose EXIF holds a single IFD with entries, followed by more IFDs
+func jpegWithIFD0(entries []entry, more ...[]byte) []byte {
+	tiff := append([]byte("II\x2a\x00\x08\x00\x00\x00"), ifd(8, entries...)...)
+	tiff = append(tiff, bytes.Join(more, nil)...)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}
+	jpeg = append(jpeg, app1...)
+	return append(jpeg, 0xFF, 0xC0, 0, 11, 8, 0, 2, 0, 3, 1, 1, 0x11, 0, 0xFF, 0xD9)
+}
+
+// A JPEG with make, model, capture time & GPS, pointing at an Exif & a GPS IFD
+func fullJPEG() []byte {
+	rationals := make([]byte, 24)
+	for i, v := range []uint32{40, 45, 0} {
+		binary.LittleEndian.PutUint32(rationals[i*8:], v)
+		binary.LittleEndian.PutUint32(rationals[i*8+4:], 1)
+	}
+	pointer := func(tag uint16, offset int) entry {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(offset))
+		return entry{tag, 4, 1, b}
+	}
+	ifd0 := func(exifAt, gpsAt int) []byte {
+		return ifd(8, ascii(tagMake, "Canon"), ascii(tagModel, "Canon EOS R5"), pointer(tagExifIFD, exifAt), pointer(tagGPSIFD, gpsAt))
+	}
+	exifAt := 8 + len(ifd0(0, 0))
+	exif := ifd(exifAt, ascii(tagDateTimeOriginal, "2021:06:01 18:30:00"), ascii(tagOffsetTimeOriginal, "+02:00"))
+	gpsAt := exifAt + len(exif)
+	gps := ifd(gpsAt, ascii(tagGPSLatitudeRef, "N"), entry{tagGPSLatitude, 5, 3, rationals}, ascii(tagGPSLongitudeRef, "W"), entry{tagGPSLongitude, 5, 3, rationals})
+
+	tiff := append([]byte("II\x2a\x00\x08\x00\x00\x00"), ifd0(exifAt, gpsAt)...)
+	tiff = append(append(tiff, exif...), gps...)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}
+	return append(append(jpeg, app1...), 0xFF, 0xD9)
+}
+
+func box(typ string, payload ...[]byte) []byte {
+	b := bytes.Join(payload, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(8+len(b)))
+	copy(header[4:], typ)
+	return append(header, b...)
+}
+
+// An MP4 recording its creation time in mvhd & its device & location in Apple's meta keys
+func fullMP4() []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[4:], uint32(time.Date(2021, 6, 1, 16, 30, 0, 0, time.UTC).Unix()+mp4Epoch))
+
+	key := func(name string) []byte { return box("mdta", []byte(name)) }
+	keys := append([]byte{0, 0, 0, 0, 0, 0, 0, 3}, bytes.Join([][]byte{
+		key("com.apple.quicktime.make"), key("com.apple.quicktime.model"), key("com.apple.quicktime.location.ISO6709"),
+	}, nil)...)
+	item := func(index byte, value string) []byte {
+		return box(string([]byte{0, 0, 0, index}), box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(value)))
+	}
+	meta := box("meta", box("keys", keys), box("ilst", item(1, "Apple"), item(2, "iPhone 12"), item(3, "+40.7500-073.9800/")))
+
+	return append(box("ftyp", []byte("isom\x00\x00\x02\x00")), box("moov", box("mvhd", mvhd), meta)...)
+}
+
+func TestExtractJPEG(t *testing.T) {
+	m, err := Extract(fullJPEG())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Format != "jpeg" || m.Make != "Canon" || m.Model != "Canon EOS R5" || m.CapturedOffset != "+02:00" || !m.HasLocation || m.Latitude != 40.75 || m.Longitude != -40.75 {
+		t.Errorf("metadata = %+v", m)
+	}
+	if want := time.Date(2021, 6, 1, 16, 30, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond); m.Captured != want {
+		t.Errorf("captured = %d, want %d", m.Captured, want)
+	}
+}
+
+func TestExtractMP4(t *testing.T) {
+	m, err := Extract(fullMP4())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Format != "mp4" || m.Make != "Apple" || m.Model != "iPhone 12" || !m.HasLocation || m.Latitude != 40.75 || m.Longitude != -73.98 {
+		t.Errorf("metadata = %+v", m)
+	}
+	if want := time.Date(2021, 6, 1, 16, 30, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond); m.Captured != want {
+		t.Errorf("captured = %d, want %d", m.Captured, want)
+	}
+}
+
+// The files in testdata are written big-endian, & the video keeps its metadata in QuickTime user data
+func TestExtractTestdata(t *testing.T) {
+	tests := []struct {
+		file     string
+		want     Metadata
+		captured time.Time
+	}{
+		{
+			"canon_eos_r5.jpg",
+			Metadata{Format: "jpeg", Make: "Canon", Model: "Canon EOS R5", CapturedOffset: "+02:00", HasLocation: true, Latitude: 40.75, Longitude: -73.98, Width: 3, Height: 2},
+			time.Date(2021, 6, 1, 16, 30, 0, 0, time.UTC),
+		},
+		{
+			"iphone_12.mp4",
+			Metadata{Format: "mp4", Make: "Apple", Model: "iPhone 12", HasLocation: true, Latitude: 40.75, Longitude: -73.98},
+			time.Date(2021, 6, 1, 17, 30, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		data, err := os.ReadFile("testdata/" + test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Extract(data)
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+			continue
+		}
+		test.want.Captured = test.captured.UnixNano() / int64(time.Millisecond)
+		if math.Abs(m.Latitude-test.want.Latitude) < 1e-6 && math.Abs(m.Longitude-test.want.Longitude) < 1e-6 {
+			m.Latitude, m.Longitude = test.want.Latitude, test.want.Longitude
+		}
+		if m != test.want {
+			t.Errorf("%s: metadata = %+v, want %+v", test.file, m, test.want)
+		}
+	}
+}
+
+func TestExtractSkipsEntriesWithoutValues(t *testing.T) {
+	for _, e := range []entry{
+		{tagOrientation, 3, 0, nil},
+		{tagOrientation, 4, 0, nil},
+		{tagMake, 2, 0, nil},
+		{tagExifIFD, 4, 0, nil},
+		{tagGPSIFD, 3, 0, nil},
+	} {
+		m, err := Extract(jpegWithIFD0([]entry{ascii(tagModel, "X100V"), e}))
+		if err != nil {
+			t.Errorf("tag %#x with count 0: %v", e.tag, err)
+		}
+		if m.Model != "X100V" || m.Orientation != 0 || m.Make != "" {
+			t.Errorf("tag %#x with count 0: metadata = %+v", e.tag, m)
+		}
+	}
+
+	// GPS coordinates with fewer than 3 rationals
+	short := []byte{40, 0, 0, 0, 1, 0, 0, 0}
+	gpsAt := 8 + len(ifd(8, entry{}))
+	gps := ifd(gpsAt, entry{tagGPSLatitude, 5, 1, short}, entry{tagGPSLongitude, 5, 3, short})
+	m, err := Extract(jpegWithIFD0([]entry{{tagGPSIFD, 4, 1, []byte{byte(gpsAt), 0, 0, 0}}}, gps))
+	if err != nil || m.HasLocation {
+		t.Errorf("short GPS coordinates: metadata = %+v", m)
+	}
+}
+
+func TestExtractTruncatedFiles(t *testing.T) {
+	for name, file := range map[string][]byte{"jpeg": fullJPEG(), "mp4": fullMP4()} {
+		for n := 0; n < len(file); n++ {
+			// Only checks that nothing panics, truncated files may or may not have an error
+			Extract(file[:n])
+		}
+		t.Log(name, "truncated at every length")
+	}
+}
+
+func TestExtractCorruptedFiles(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, file := range [][]byte{fullJPEG(), fullMP4()} {
+		for k := 0; k < 5000; k++ {
+			corrupt := append([]byte(nil), file...)
+			for flips := 1 + r.Intn(4); flips > 0; flips-- {
+				// Keep the signature so the parsers are reached
+				corrupt[4+r.Intn(len(corrupt)-4)] = byte(r.Intn(256))
+			}
+			Extract(corrupt)
+		}
+	}
+}
+
+func TestExtractRejectsMalformedMP4(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom"))
+
+	// A box claiming to be larger than the file
+	tooLarge := box("moov", box("mvhd", make([]byte, 100)))
+	binary.BigEndian.PutUint32(tooLarge, 1<<30)
+	if _, err := Extract(append(ftyp, tooLarge...)); err == nil {
+		t.Error("box larger than the file: no error")
+	}
+
+	// A 64-bit size smaller than its header
+	large := []byte{0, 0, 0, 1, 'm', 'o', 'o', 'v', 0, 0, 0, 0, 0, 0, 0, 4}
+	if _, err := Extract(append(ftyp, large...)); err == nil {
+		t.Error("64-bit size smaller than its header: no error")
+	}
+
+	// Boxes nested deeper than any metadata
+	nested := box("udta")
+	for k := 0; k < 10000; k++ {
+		nested = box("moov", nested)
+	}
+	if _, err := Extract(append(ftyp, nested...)); err == nil {
+		t.Error("deeply nested boxes: no error")
+	}
+}
+
+func TestExtractRejectsUnknownFormats(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("image g1"), {0xFF}} {
+		if _, err := Extract(data); err != ErrUnknownFormat {
+			t.Errorf("Extract(%q) = %v, want ErrUnknownFormat", data, err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package mediameta
+
+import (
+	"encoding/binary"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Seconds between 1904-01-01, when MP4 times start, & the Unix epoch
+const mp4Epoch = 2082844800
+
+// Boxes that hold the boxes metadata is read from
+var mp4Containers = map[string]bool{"moov": true, "trak": true, "udta": true, "meta": true}
+
+// How deep boxes can be nested, metadata is never more than a few levels down
+const maxMP4Depth = 16
+
+type mp4Reader struct {
+	m *Metadata
+
+	// How many boxes deep the reader is
+	depth int
+
+	// Names from the meta keys box, by their 1-based index in it
+	keys []string
+
+	// When the file's own creation date is recorded as text, which beats mvhd's as it has an offset
+	creationDate string
+}
+
+// Reads the MP4's boxes: mvhd & tkhd for the creation time & size,
+// udta & meta for the device, software, location & creation date phones record
+func extractMP4(data []byte) (Metadata, error) {
+	m := Metadata{Format: "mp4"}
+	r := mp4Reader{m: &m}
+	if err := r.boxes(data); err != nil {
+		return m, err
+	}
+
+	if r.creationDate != "" {
+		for _, layout := range []string{"2006-01-02T15:04:05-0700", "2006-01-02T15:04:05Z07:00"} {
+			if t, err := time.Parse(layout, r.creationDate); err == nil {
+				m.Captured = t.UnixNano() / int64(time.Millisecond)
+				m.CapturedOffset = t.Format("-07:00")
+				break
+			}
+		}
+	}
+	return m, nil
+}
+
+// Reads each box in data
+func (r *mp4Reader) boxes(data []byte) error {
+	if r.depth >= maxMP4Depth {
+		return errors.New("mediameta: MP4 boxes nested too deep")
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return errTruncated
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return errTruncated
+		}
+
+		if err := r.box(typ, data[header:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+func (r *mp4Reader) box(typ string, payload []byte) error {
+	switch {
+	case typ == "meta":
+		// ISO meta boxes have a version & flags before their children, QuickTime ones do not
+		if len(payload) >= 8 && binary.BigEndian.Uint32(payload) == 0 {
+			payload = payload[4:]
+		}
+		return r.boxes(payload)
+	case mp4Containers[typ]:
+		return r.boxes(payload)
+	case typ == "mvhd":
+		r.mvhd(payload)
+	case typ == "tkhd":
+		r.tkhd(payload)
+	case typ == "keys":
+		r.readKeys(payload)
+	case typ == "ilst":
+		r.ilst(payload)
+	case strings.HasPrefix(typ, "\xa9"):
+		r.item(typ, userDataText(payload))
+	}
+	return nil
+}
+
+func (r *mp4Reader) mvhd(p []byte) {
+	var created uint64
+	switch {
+	case len(p) >= 12 && p[0] == 0:
+		created = uint64(binary.BigEndian.Uint32(p[4:]))
+	case len(p) >= 20 && p[0] == 1:
+		created = binary.BigEndian.Uint64(p[4:])
+	default:
+		return
+	}
+	if created > mp4Epoch && r.m.Captured == 0 {
+		r.m.Captured = int64(created-mp4Epoch) * 1000
+	}
+}
+
+// The first track with a size is the video's
+func (r *mp4Reader) tkhd(p []byte) {
+	offset := 76
+	if len(p) > 0 && p[0] == 1 {
+		offset = 88
+	}
+	if len(p) < offset+8 || r.m.Width != 0 {
+		return
+	}
+	r.m.Width = int(binary.BigEndian.Uint32(p[offset:]) >> 16)
+	r.m.Height = int(binary.BigEndian.Uint32(p[offset+4:]) >> 16)
+}
+
+func (r *mp4Reader) readKeys(p []byte) {
+	if len(p) < 8 {
+		return
+	}
+	count := int(binary.BigEndian.Uint32(p[4:]))
+	p = p[8:]
+	for k := 0; k < count && len(p) >= 8; k++ {
+		size := int(binary.BigEndian.Uint32(p))
+		if size < 8 || size > len(p) {
+			return
+		}
+		r.keys = append(r.keys, string(p[8:size]))
+		p = p[size:]
+	}
+}
+
+// The items of a meta box, each named by a key's index or by its type, with its value in a data box
+func (r *mp4Reader) ilst(p []byte) {
+	for len(p) >= 8 {
+		size := int(binary.BigEndian.Uint32(p))
+		if size < 8 || size > len(p) {
+			return
+		}
+		item, typ := p[8:size], p[4:8]
+		p = p[size:]
+
+		value, ok := dataBoxText(item)
+		if !ok {
+			continue
+		}
+		if index := int(binary.BigEndian.Uint32(typ)); index >= 1 && index <= len(r.keys) {
+			r.item(r.keys[index-1], value)
+		} else {
+			r.item(string(typ), value)
+		}
+	}
+}
+
+// Records an item from udta or meta, named by its QuickTime type or key
+func (r *mp4Reader) item(name, value string) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if value == "" {
+		return
+	}
+	switch name {
+	case "\xa9mak", "com.apple.quicktime.make":
+		r.m.Make = value
+	case "\xa9mod", "com.apple.quicktime.model":
+		r.m.Model = value
+	case "\xa9swr", "\xa9too", "com.apple.quicktime.software":
+		r.m.Software = value
+	case "\xa9day", "com.apple.quicktime.creationdate":
+		r.creationDate = value
+	case "\xa9xyz", "com.apple.quicktime.location.ISO6709":
+		if lat, lon, ok := parseISO6709(value); ok {
+			r.m.HasLocation, r.m.Latitude, r.m.Longitude = true, lat, lon
+		}
+	}
+}
+
+// The text of a QuickTime user data item: a 2 byte length & language, then the text,
+// or a data box like the items of a meta box
+func userDataText(p []byte) string {
+	if value, ok := dataBoxText(p); ok {
+		return value
+	}
+	if len(p) < 4 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(p))
+	if n > len(p)-4 {
+		n = len(p) - 4
+	}
+	return string(p[4 : 4+n])
+}
+
+// The value of the data box at the start of p: type & locale, then the value
+func dataBoxText(p []byte) (string, bool) {
+	if len(p) < 16 || string(p[4:8]) != "data" {
+		return "", false
+	}
+	size := int(binary.BigEndian.Uint32(p))
+	if size < 16 || size > len(p) {
+		return "", false
+	}
+	return string(p[16:size]), true
+}
+
+var iso6709 = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+
+// Parses a location like +40.7500-073.9800+010.000/ in decimal degrees
+func parseISO6709(s string) (float64, float64, bool) {
+	match := iso6709.FindStringSubmatch(s)
+	if match == nil {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lon, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// High-priority messages from a run, e.g. a reserved username was taken
+func (r runReport) alerts() []string {
+	var alerts []string
+	for _, c := range r.StatusChanges {
+		if c.Change.IsCreation() {
+			created := time.Unix(c.Change.At, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+			alerts = append(alerts, fmt.Sprintf("%s: reserved username %s was created on %s (found %s)", c.TargetName, c.Username, c.Platform, created))
+		}
+	}
+	return alerts
+}
+
+// Prints the alerts & runs Config.yaml's notify command once per alert, with the alert as its last argument
+func notify(config totemConfig, alerts []string) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "!!!!!!!!Alerts!!!!!!!!")
+	for _, a := range alerts {
+		fmt.Fprintln(os.Stderr, a)
+
+		if len(config.Notify) == 0 {
+			continue
+		}
+		args := append(append([]string(nil), config.Notify[1:]...), a)
+		if err := exec.Command(config.Notify[0], args...).Run(); err != nil {
+			fmt.Fprintln(os.Stderr, "notify:", err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"Totem/catalog"
+	"Totem/mediameta"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A place where the target posted from repeatedly
+type place struct {
+	// Centre of the place's posts
+	Latitude  float64
+	Longitude float64
+
+	Posts []catalog.Media
+
+	// Distinct days with a post from the place
+	Visits int
+
+	// Posts by part of the day: night (0-6h), morning (6-12h), afternoon (12-18h) & evening (18-24h)
+	DayParts [4]int
+
+	Weekdays int
+	Weekends int
+
+	// "home", "work", "school" or empty
+	Label string
+}
+
+var dayPartNames = [4]string{"night", "morning", "afternoon", "evening"}
+
+// Clusters posts with DBSCAN: posts with at least minPosts posts, themselves included, within radius metres
+// start a place, & places grow through every post within radius of one of those.
+// Posts that end up in no place are left out. Times are read in loc.
+// Places are ranked by visits, then by posts.
+func findPlaces(posts []catalog.Media, radius float64, minPosts int, loc *time.Location) []place {
+	const noise = -1
+	labels := make([]int, len(posts))
+
+	neighbours := func(i int) []int {
+		var n []int
+		for j := range posts {
+			if j != i && mediameta.Distance(posts[i].Latitude, posts[i].Longitude, posts[j].Latitude, posts[j].Longitude) <= radius {
+				n = append(n, j)
+			}
+		}
+		return n
+	}
+
+	clusters := 0
+	for i := range posts {
+		if labels[i] != 0 {
+			continue
+		}
+		queue := neighbours(i)
+		if len(queue)+1 < minPosts {
+			labels[i] = noise
+			continue
+		}
+
+		clusters++
+		labels[i] = clusters
+		for len(queue) > 0 {
+			j := queue[0]
+			queue = queue[1:]
+			if labels[j] == noise {
+				labels[j] = clusters
+			}
+			if labels[j] != 0 {
+				continue
+			}
+			labels[j] = clusters
+			if n := neighbours(j); len(n)+1 >= minPosts {
+				queue = append(queue, n...)
+			}
+		}
+	}
+
+	places := make([]place, clusters)
+	for i, m := range posts {
+		if labels[i] == noise {
+			continue
+		}
+		places[labels[i]-1].Posts = append(places[labels[i]-1].Posts, m)
+	}
+	for i := range places {
+		places[i].summarize(loc)
+	}
+
+	sort.SliceStable(places, func(i, j int) bool {
+		if places[i].Visits != places[j].Visits {
+			return places[i].Visits > places[j].Visits
+		}
+		return len(places[i].Posts) > len(places[j].Posts)
+	})
+	labelPlaces(places)
+	return places
+}
+
+// Sets the place's centre, visits & time distribution from its posts
+func (p *place) summarize(loc *time.Location) {
+	days := make(map[string]struct{})
+	for _, m := range p.Posts {
+		p.Latitude += m.Latitude / float64(len(p.Posts))
+		p.Longitude += m.Longitude / float64(len(p.Posts))
+
+		t := m.Time().In(loc)
+		days[t.Format("2006-01-02")] = struct{}{}
+		p.DayParts[t.Hour()/6]++
+		if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+			p.Weekends++
+		} else {
+			p.Weekdays++
+		}
+	}
+	p.Visits = len(days)
+}
+
+// Labels the highest ranked place that is mostly posted from at night & in the evening as home,
+// & the highest ranked one mostly posted from on weekday mornings & afternoons as work, or as school
+// if its posts are mostly in the morning. A place needs at least 2 visits for a label.
+func labelPlaces(places []place) {
+	labelled := make(map[string]bool)
+	for i := range places {
+		p := &places[i]
+		posts := len(p.Posts)
+		if p.Visits < 2 {
+			continue
+		}
+
+		atHome := p.DayParts[0] + p.DayParts[3]
+		daytime := p.DayParts[1] + p.DayParts[2]
+
+		label := ""
+		switch {
+		case atHome*2 > posts:
+			label = "home"
+		case daytime*2 > posts && p.Weekdays*2 > posts:
+			label = "work"
+			if p.DayParts[1] > p.DayParts[2] {
+				label = "school"
+			}
+		}
+
+		if label != "" && !labelled[label] {
+			p.Label = label
+			labelled[label] = true
+		}
+	}
+}
+
+// Prints the places the target's gallery posts cluster around.
+// Times of day are read in offsetFlag, or the target's likely UTC offset if it is empty, like printActivity.
+func printPlaces(targetName string, radius float64, minPosts int, offsetFlag string) error {
+	media, err := catalogDB.SearchMedia(catalog.Query{Target: targetName})
+	if err != nil {
+		return err
+	}
+
+	// Every post tells when the target is awake, only geotagged ones where
+	var posts []catalog.Media
+	var times []time.Time
+	for _, m := range media {
+		if m.Collected != 0 || m.Uploaded == 0 {
+			continue
+		}
+		times = append(times, m.Time())
+		if m.HasLocation {
+			posts = append(posts, m)
+		}
+	}
+
+	offset, err := targetOffset(times, offsetFlag)
+	if err != nil {
+		return err
+	}
+
+	zone := time.FixedZone(formatUTCOffset(offset), int(offset.Seconds()))
+	places := findPlaces(posts, radius, minPosts, zone)
+	if len(places) == 0 {
+		fmt.Println("No places found in", len(posts), "geotagged posts")
+		return nil
+	}
+
+	clustered := 0
+	for i, p := range places {
+		clustered += len(p.Posts)
+
+		label := ""
+		if p.Label != "" {
+			label = " | likely " + p.Label
+		}
+		fmt.Printf("#%d %.5f, %.5f | %d posts on %d days%s\n", i+1, p.Latitude, p.Longitude, len(p.Posts), p.Visits, label)
+
+		first := p.Posts[0].Time().In(zone).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+		last := p.Posts[len(p.Posts)-1].Time().In(zone).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+		fmt.Println("   ", first, "-", last)
+
+		parts := ""
+		for k, n := range p.DayParts {
+			parts += " " + dayPartNames[k] + " " + strconv.Itoa(n)
+		}
+		fmt.Println("   "+parts, "| weekdays", p.Weekdays, "weekends", p.Weekends)
+	}
+	fmt.Println(len(posts)-clustered, "of", len(posts), "geotagged posts are not at a place")
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"Totem/catalog"
+	"Totem/service"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// What happened during a run, printed once it is done
+type runReport struct {
+	Failures []accountFailure
+
+	// Accounts whose status changed during the run
+	StatusChanges []accountStatusChange
+}
+
+// A step of a run that failed for one account
+type accountFailure struct {
+	TargetName string
+	Username   string
+	Platform   string
+	Step       string
+	Err        error
+}
+
+type accountStatusChange struct {
+	TargetName string
+	Username   string
+	Platform   string
+	Change     service.StatusChange
+}
+
+func (r *runReport) add(other runReport) {
+	r.Failures = append(r.Failures, other.Failures...)
+	r.StatusChanges = append(r.StatusChanges, other.StatusChanges...)
+}
+
+func runAllActiveTrackingProfiles(ctx context.Context, config totemConfig, trackingProfiles []service.TrackingProfile) runReport {
+	var report runReport
+	for i := range trackingProfiles {
+		if trackingProfiles[i].Active {
+			report.add(getUser(ctx, config, &trackingProfiles[i]))
+		}
+	}
+	return report
+}
+
+func runTrackingProfile(ctx context.Context, config totemConfig, targetName string, trackingProfiles []service.TrackingProfile) runReport {
+	var report runReport
+	for i := range trackingProfiles {
+		if trackingProfiles[i].TargetName == targetName {
+			report.add(getUser(ctx, config, &trackingProfiles[i]))
+		}
+	}
+	return report
+}
+
+// Prints the accounts whose status changed, the failed steps,
+// & the steps that were left undone if the run was stopped
+func printRunReport(report runReport) {
+	if len(report.StatusChanges) > 0 {
+		fmt.Println("--------Status changes--------")
+		for _, c := range report.StatusChanges {
+			fmt.Println(c.TargetName, "/", c.Username, "["+c.Platform+"]", formatStatus(c.Change.From), "->", c.Change.To)
+		}
+	}
+
+	var failed, undone []accountFailure
+	for _, f := range report.Failures {
+		if errors.Is(f.Err, context.Canceled) {
+			undone = append(undone, f)
+		} else {
+			failed = append(failed, f)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintln(os.Stderr, "--------Failures--------")
+		for _, f := range failed {
+			fmt.Fprintln(os.Stderr, f.TargetName, "/", f.Username, "["+f.Platform+"]", f.Step+":", f.Err)
+		}
+	}
+
+	if len(undone) > 0 {
+		fmt.Fprintln(os.Stderr, "--------Left undone--------")
+		for _, f := range undone {
+			fmt.Fprintln(os.Stderr, f.TargetName, "/", f.Username, "["+f.Platform+"]", f.Step+":", f.Err)
+		}
+	}
+}
+
+func formatStatus(status string) string {
+	if status == "" {
+		return "untracked"
+	}
+	return status
+}
+
+// Runs the account's service on a TrackingProfile & all of it's accounts.
+// A failing account is recorded & skipped, a failing step only skips that step.
+// Once ctx is cancelled the remaining steps are recorded as left undone.
+func getUser(ctx context.Context, config totemConfig, tp *service.TrackingProfile) runReport {
+	var report runReport
+	userpath := totemPath + "/" + tp.TargetName
+
+	if _, err := os.Stat(userpath); errors.Is(err, os.ErrNotExist) {
+		err := os.Mkdir(userpath, os.ModePerm)
+		if err != nil {
+			report.Failures = append(report.Failures, accountFailure{tp.TargetName, "", "", "create target directory", err})
+			return report
+		}
+	}
+
+	os.Chdir(userpath)
+
+	if catalogDB != nil {
+		if err := catalogDB.PutTarget(catalog.Target{Name: tp.TargetName, Active: tp.Active}); err != nil {
+			report.Failures = append(report.Failures, accountFailure{tp.TargetName, "", "", "update catalog", err})
+		}
+	}
+
+	for i, a := range tp.Accounts {
+		fail := func(step string, err error) {
+			report.Failures = append(report.Failures, accountFailure{tp.TargetName, tp.Accounts[i].Username, a.Platform, step, err})
+		}
+
+		if err := ctx.Err(); err != nil {
+			fail("run", err)
+			continue
+		}
+
+		accountPath := service.AccountDir(userpath, a.Platform, a.Username)
+		if _, err := os.Stat(accountPath); errors.Is(err, os.ErrNotExist) {
+			err := os.MkdirAll(accountPath, os.ModePerm)
+			if err != nil {
+				fail("create account directory", err)
+				continue
+			}
+		}
+
+		os.Chdir(accountPath)
+
+		s, err := service.New(a.Platform, &tp.Accounts[i], userpath, config.serviceConfig(a.Platform))
+		if err != nil {
+			fail("create service", err)
+			continue
+		}
+
+		statusChanges := len(tp.Accounts[i].StatusChanges)
+		err = s.ResolveAccount(ctx)
+		for _, c := range tp.Accounts[i].StatusChanges[statusChanges:] {
+			report.StatusChanges = append(report.StatusChanges, accountStatusChange{tp.TargetName, tp.Accounts[i].Username, a.Platform, c})
+		}
+		if err != nil {
+			fail("resolve account", err)
+			continue
+		}
+
+		if err := s.FetchBio(ctx); err != nil {
+			fail("fetch bio", err)
+		}
+		if err := s.FetchProfile(ctx); err != nil {
+			fail("fetch profile", err)
+		}
+		for _, source := range s.MediaSources() {
+			if err := s.DownloadMedia(ctx, source); err != nil {
+				fail("download "+source.Name, err)
+			}
+		}
+
+		os.Chdir("..")
+	}
+
+	return report
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"Totem/catalog"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Accepted by --from & --to, in local time
+var searchDateLayouts = []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339}
+
+// Parses a --from or --to date. Dates without a time of day start at midnight,
+// or end at midnight for --to so the whole day is included.
+func parseSearchDate(s string, end bool) (time.Time, error) {
+	for _, layout := range searchDateLayouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err != nil {
+			continue
+		}
+		if end && layout == "2006-01-02" {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD", s)
+}
+
+// Prints the posts & bios in the catalog matching q
+func searchCatalog(q catalog.Query) {
+	media, err := catalogDB.SearchMedia(q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	bios, err := catalogDB.SearchBios(q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	for _, m := range media {
+		ts := m.Time().Format("Mon, Jan 2, 15h04m05s, MST 2006")
+		fmt.Println(ts, "|", formatAccountRef(m.AccountRef), m.Source, "|", summarize(m.Description), "|", m.File)
+		for _, d := range m.Discrepancies {
+			fmt.Println("   ! " + d)
+		}
+	}
+	for _, b := range bios {
+		ts := time.Unix(b.Recorded, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+		fmt.Println(ts, "|", formatAccountRef(b.AccountRef), "Bio", "|", summarize(b.Description))
+	}
+	fmt.Println(len(media), "posts,", len(bios), "bios")
+}
+
+func formatAccountRef(a catalog.AccountRef) string {
+	return a.Target + " / " + a.Username + " [" + a.Platform + "]"
+}
+
+// s on a single line, shortened to 60 characters
+func summarize(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 60 {
+		return string(r[:59]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,45 @@
+package service
+
+import "errors"
+
+// Kinds of failures a Service reports. Check for them with errors.Is.
+var (
+	// The account or media does not exist on the platform
+	ErrNotFound = errors.New("not found")
+
+	// The platform is refusing requests because too many were made
+	ErrRateLimited = errors.New("rate limited")
+
+	// The request could not be made or the platform returned an unexpected response
+	ErrTransport = errors.New("transport failure")
+
+	// The platform's response could not be decoded
+	ErrDecode = errors.New("decode failure")
+
+	// Reading or writing the local archive failed
+	ErrStorage = errors.New("storage failure")
+)
+
+// A failure from a Service.
+// Kind is one of the Err* kinds above, Op describes what was being done.
+type Error struct {
+	Kind error
+	Op   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Op + ": " + e.Kind.Error()
+	}
+	return e.Op + ": " + e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Makes errors.Is(err, ErrNotFound) etc. match the error's kind
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Kinds of Event
+const (
+	// A media that was archived is no longer on the platform
+	EventMediaDeleted = "media_deleted"
+
+	// A media that was recorded as deleted is back on the platform
+	EventMediaRestored = "media_restored"
+
+	// A media's caption, tags, location etc. changed
+	EventMediaEdited = "media_edited"
+
+	// The account has a new profile image, MediaID is its image ID
+	EventProfileImageChanged = "profile_image_changed"
+
+	// One of the account's identifying fields, e.g. its username, changed, Changes is [field, old, new]
+	EventIdentityChanged = "identity_changed"
+
+	// The account's status changed, Changes is [from, to]
+	EventStatusChanged = "status_changed"
+)
+
+// Something that changed on an account, recorded in the account's history.
+// Time is when Totem noticed the change.
+type Event struct {
+	Kind string `json:"kind"`
+	Time int64  `json:"time"`
+
+	// Set for media events, e.g. "Gallery"
+	Source  string `json:"source,omitempty"`
+	MediaID string `json:"media_id,omitempty"`
+
+	// When the media was last seen on the platform, 0 if it is not known
+	LastSeen int64 `json:"last_seen,omitempty"`
+
+	// What changed, e.g. "description"
+	Changes []string `json:"changes,omitempty"`
+}
+
+// Path: ./Totem/{TrackingProfile.TargetName}/{Account}/.History.json
+func historyFile(accountDir string) string {
+	return accountDir + "/.History.json"
+}
+
+// Reads the account's history, oldest event first
+func ReadHistory(accountDir string) ([]Event, error) {
+	var events []Event
+
+	bytes, err := os.ReadFile(historyFile(accountDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, &Error{Kind: ErrStorage, Op: "read history", Err: err}
+	}
+
+	err = json.Unmarshal(bytes, &events)
+	if err != nil {
+		return nil, &Error{Kind: ErrStorage, Op: "decode history", Err: err}
+	}
+	return events, nil
+}
+
+// Adds events to the end of the account's history
+func AppendHistory(accountDir string, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	history, err := ReadHistory(accountDir)
+	if err != nil {
+		return err
+	}
+	history = append(history, events...)
+
+	bytes, err := json.Marshal(history)
+	if err != nil {
+		return &Error{Kind: ErrStorage, Op: "encode history", Err: err}
+	}
+
+	err = os.WriteFile(historyFile(accountDir), bytes, os.ModePerm)
+	if err != nil {
+		return &Error{Kind: ErrStorage, Op: "write history", Err: err}
+	}
+	return nil
+}
+
+// A one line description of the event, without its time
+func (e Event) Description() string {
+	switch e.Kind {
+	case EventMediaDeleted:
+		return fmt.Sprintf("%s media %s was deleted, last seen %s", e.Source, e.MediaID, formatUnix(e.LastSeen))
+	case EventMediaRestored:
+		return fmt.Sprintf("%s media %s is back after being deleted", e.Source, e.MediaID)
+	case EventMediaEdited:
+		return fmt.Sprintf("%s media %s was edited: %s", e.Source, e.MediaID, strings.Join(e.Changes, ", "))
+	case EventProfileImageChanged:
+		return fmt.Sprintf("New profile image %s", e.MediaID)
+	case EventIdentityChanged:
+		if len(e.Changes) == 3 {
+			return fmt.Sprintf("%s changed from %s to %s", strings.Replace(e.Changes[0], "_", " ", -1), e.Changes[1], e.Changes[2])
+		}
+	case EventStatusChanged:
+		if len(e.Changes) == 2 && e.Changes[0] == StatusNeverExisted && e.Changes[1] == StatusActive {
+			return "Account was created"
+		}
+		if len(e.Changes) == 2 {
+			return fmt.Sprintf("Account went from %s to %s", e.Changes[0], e.Changes[1])
+		}
+	}
+	return e.Kind
+}
+
+func formatUnix(t int64) string {
+	if t == 0 {
+		return "unknown"
+	}
+	return time.Unix(t, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+}
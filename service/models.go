@@ -0,0 +1,158 @@
+package service
+
+// Tracking profile:
+// - Multiple accounts can be owned by one person
+// - You can reserve usernames in case that person creates an account
+// - Accounts can be on different platforms
+// - The platform's identifiers get saved, in case the person changes their name
+type TrackingProfile struct {
+	// The name of the person's whose accounts you are tracking
+	TargetName string `json:"target_name"`
+
+	// Currently tracking this target
+	Active bool
+
+	// Accounts you are tracking.
+	Accounts []Account
+}
+
+type Account struct {
+	// The platform the account is on, e.g. "vsco"
+	Platform string `json:"platform"`
+
+	Username string `json:"username"`
+
+	// Platform specific identifiers, e.g. VSCO's site_id & user_id.
+	// Identifiers that are not known yet are left out.
+	IDs map[string]string `json:"ids" yaml:",omitempty"`
+
+	// Every name the account has been seen with, oldest change first. Only ever appended to.
+	Identities []IdentityChange `json:"identities" yaml:",omitempty"`
+
+	// One of the Status* values, empty until the account is first looked up
+	Status string `json:"status" yaml:",omitempty"`
+
+	// Every change of Status, oldest first. Setting the status on the first lookup is not a change.
+	StatusChanges []StatusChange `json:"status_changes" yaml:",omitempty"`
+
+	// When Status was set
+	StatusSince int64 `json:"status_since" yaml:",omitempty"`
+
+	// When a reserved username was first found on the platform, i.e. about when the account was created
+	Created int64 `json:"created" yaml:",omitempty"`
+}
+
+// Where the account's archive is kept in its target's directory.
+// Accounts are grouped by platform, so accounts with the same username on different platforms are kept apart.
+func AccountDir(targetDir string, platform string, username string) string {
+	return targetDir + "/" + platform + "/" + username
+}
+
+// Account statuses
+const (
+	// The account exists on the platform
+	StatusActive = "active"
+
+	// The account existed, but could not be found on the last run
+	StatusMissing = "missing"
+
+	// The account has been missing for at least DeletedAfter
+	StatusDeleted = "deleted"
+
+	// The account is back after being missing or deleted, it becomes active on the next run
+	StatusRestored = "restored"
+
+	// The username has never been found. Reserved usernames stay in this status
+	// & are looked up every run until someone creates the account.
+	StatusNeverExisted = "never-existed"
+)
+
+// How long an account has to be missing before it is considered deleted
+const DeletedAfter = 24 * 60 * 60
+
+// The account's Status changed from From to To
+type StatusChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	At   int64  `json:"at"`
+}
+
+// One of the account's identifying fields changed from Old to New
+type IdentityChange struct {
+	// Only FieldUsername for now, as VSCO has no display name: a site's name is its username
+	Field      string `json:"field"`
+	Old        string `json:"old"`
+	New        string `json:"new"`
+	DetectedAt int64  `json:"detected_at"`
+}
+
+const FieldUsername = "username"
+
+// Returns the platform specific identifier for key, or "" if it is not known
+func (a *Account) ID(key string) string {
+	return a.IDs[key]
+}
+
+// Records that the account's field changed from old to new, detected at time t
+func (a *Account) RecordIdentityChange(field string, old string, new string, t int64) {
+	a.Identities = append(a.Identities, IdentityChange{field, old, new, t})
+}
+
+// Moves the account to its next status depending on whether it was found on the platform at time t.
+// Returns the change if the status changed. The first lookup only sets the status, so it returns no change.
+func (a *Account) UpdateStatus(found bool, t int64) (StatusChange, bool) {
+	from := a.Status
+	to := from
+
+	switch {
+	case found && (from == StatusMissing || from == StatusDeleted):
+		to = StatusRestored
+	case found:
+		to = StatusActive
+	case from == "" && len(a.IDs) > 0:
+		// Tracked before statuses were recorded, so it existed at some point
+		to = StatusMissing
+	case from == "" || from == StatusNeverExisted:
+		to = StatusNeverExisted
+	case from == StatusActive || from == StatusRestored:
+		to = StatusMissing
+	case from == StatusMissing && t-a.statusSince() >= DeletedAfter:
+		to = StatusDeleted
+	}
+
+	if to == from {
+		return StatusChange{}, false
+	}
+	a.Status, a.StatusSince = to, t
+	if from == "" {
+		return StatusChange{}, false
+	}
+	if from == StatusNeverExisted && to == StatusActive {
+		a.Created = t
+	}
+
+	change := StatusChange{from, to, t}
+	a.StatusChanges = append(a.StatusChanges, change)
+	return change, true
+}
+
+// Whether a reserved username was just found, i.e. the account was created since the last run
+func (c StatusChange) IsCreation() bool {
+	return c.From == StatusNeverExisted && c.To == StatusActive
+}
+
+// When the account's current status started, 0 if it is not known
+func (a *Account) statusSince() int64 {
+	if a.StatusSince == 0 && len(a.StatusChanges) > 0 {
+		return a.StatusChanges[len(a.StatusChanges)-1].At
+	}
+	return a.StatusSince
+}
+
+// Sets the platform specific identifier for key
+func (a *Account) SetID(key string, value string) {
+	if a.IDs == nil {
+		a.IDs = make(map[string]string)
+	}
+	a.IDs[key] = value
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"Totem/blobstore"
+	"Totem/catalog"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Creates a Service for account, storing its data under targetDir.
+type Factory func(account *Account, targetDir string, config Config) Service
+
+// Settings passed to every Service
+type Config struct {
+	// Client used for every request, the platform's default if nil
+	HTTPClient *http.Client
+
+	// Replaces the platform's API base URL if set, e.g. to use a mirror or a fake server
+	BaseURL string
+
+	// Page through all of the account's media instead of stopping at media that is already archived
+	FullScan bool
+
+	// Stores downloaded media files, shared by all of the accounts. Files are written directly if nil.
+	Blobs *blobstore.Store
+
+	// Kept in sync with everything the service stores if set
+	Catalog *catalog.DB
+}
+
+var (
+	registry = make(map[string]Factory)
+)
+
+// Makes a platform available to Totem under the name platform.
+// Platform packages call this from their init function.
+func Register(platform string, factory Factory) {
+	if factory == nil {
+		panic("service: Register factory is nil for " + platform)
+	}
+	if _, exists := registry[platform]; exists {
+		panic("service: Register called twice for " + platform)
+	}
+	registry[platform] = factory
+}
+
+// Creates the Service registered under platform for account.
+func New(platform string, account *Account, targetDir string, config Config) (Service, error) {
+	factory, ok := registry[platform]
+	if !ok {
+		return nil, fmt.Errorf("service: unknown platform %q", platform)
+	}
+	return factory(account, targetDir, config), nil
+}
+
+// The names of all registered platforms, sorted.
+func Platforms() []string {
+	platforms := make([]string, 0, len(registry))
+	for p := range registry {
+		platforms = append(platforms, p)
+	}
+	sort.Strings(platforms)
+	return platforms
+}
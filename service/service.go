@@ -0,0 +1,81 @@
+package service
+
+import "context"
+
+// A Service collects an account's data from a single platform.
+// Each platform package implements Service & registers itself with Register.
+// Failures are returned as *Error so callers can tell them apart with errors.Is.
+// Methods that take a context stop early once it is cancelled & return its error.
+type Service interface {
+	// Looks up the account on the platform & updates the Account if its information has changed.
+	// Updates the account's status with Account.UpdateStatus.
+	ResolveAccount(ctx context.Context) error
+
+	// Records changes to the account's profile, e.g. its profile image
+	FetchProfile(ctx context.Context) error
+
+	// Records changes to the account's bio
+	FetchBio(ctx context.Context) error
+
+	// Prints out the account's bio records & optionally checks if there is a new one.
+	PrintBio(ctx context.Context, withUpdate bool) error
+
+	// Prints out the account's profile images & when each was seen
+	PrintProfileImages(ctx context.Context) error
+
+	// The places on the platform that media can be collected from, e.g. a gallery
+	MediaSources() []MediaSource
+
+	// Collects all of the media from source that has not been stored locally
+	DownloadMedia(ctx context.Context, source MediaSource) error
+}
+
+// A Service that can move media stored under an older on-disk layout to the current one.
+type LayoutMigrator interface {
+	// Renames the media stored in source to the current layout without downloading it again.
+	// Returns the number of media renamed.
+	MigrateLayout(source MediaSource) (int, error)
+}
+
+// A Service that can list the media it has stored, without looking the account up on its platform.
+type MediaLister interface {
+	// The media stored in source, oldest first
+	StoredMedia(source MediaSource) ([]StoredMedia, error)
+}
+
+// Media that is stored locally
+type StoredMedia struct {
+	ID string
+
+	// The directory the media & its information are stored in
+	Dir string
+
+	// The image or video, empty if it is missing
+	File string
+
+	// Hex SHA-256 of File, empty if it was stored before hashes were recorded
+	SHA256 string
+
+	// When the media was uploaded & when it was collected, in milliseconds.
+	// Collected is 0 unless the media is from a collection.
+	Uploaded  int64
+	Collected int64
+
+	// When the media was noticed to be gone from the platform, 0 if it is not
+	Deleted int64
+}
+
+// A Service that can rebuild its account's records in the catalog from what it has stored.
+type Reindexer interface {
+	// Replaces the account's records in the catalog with ones read from disk, without looking the account up on its platform.
+	Reindex() error
+}
+
+// A place on a platform that an account's media can be collected from.
+type MediaSource struct {
+	// Name of the source, e.g. "Gallery"
+	Name string
+
+	// Path: ./Totem/{TrackingProfile.TargetName}/{Account}/{MediaSource.Name}
+	Dir string
+}
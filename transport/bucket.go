@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A token bucket shared by every request to a host
+type bucket struct {
+	mu     sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time
+
+	// Set by Retry-After, no requests are let through before it
+	pausedUntil time.Time
+}
+
+func newBucket(limit Limit) *bucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &bucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Blocks until a request can be sent or ctx is cancelled
+func (b *bucket) wait(ctx context.Context) error {
+	for {
+		delay := b.take()
+		if delay == 0 {
+			return nil
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Takes a token & returns 0, or returns how long to wait before trying again
+func (b *bucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(b.pausedUntil) {
+		return b.pausedUntil.Sub(now)
+	}
+	if b.limit.Rate <= 0 {
+		return 0
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	if delay := time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second)); delay > 0 {
+		return delay
+	}
+	return time.Nanosecond
+}
+
+func (b *bucket) pause(delay time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until := time.Now().Add(delay); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
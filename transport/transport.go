@@ -0,0 +1,208 @@
+// Package transport is an http.RoundTripper that rate limits requests per host
+// & retries requests that failed or were rate limited.
+package transport
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Requests per second allowed to a host, with up to Burst requests at once.
+// A Rate of 0 is unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Config struct {
+	// How many times a request is retried after the first attempt
+	MaxRetries int
+
+	// Backoff before the first retry, doubled for each retry after it & jittered
+	BaseDelay time.Duration
+
+	// Longest backoff between retries. Responses whose Retry-After asks for longer are returned as is,
+	// rather than stalling the run & every other request to the host.
+	MaxDelay time.Duration
+
+	// Limit per host, e.g. "vsco.co". Hosts without one use DefaultLimit.
+	Limits       map[string]Limit
+	DefaultLimit Limit
+}
+
+var (
+	DefaultConfig = Config{
+		MaxRetries:   4,
+		BaseDelay:    500 * time.Millisecond,
+		MaxDelay:     30 * time.Second,
+		DefaultLimit: Limit{Rate: 5, Burst: 5},
+	}
+)
+
+type Transport struct {
+	base   http.RoundTripper
+	config Config
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// Wraps base, http.DefaultTransport if nil
+func New(base http.RoundTripper, config Config) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{
+		base:    base,
+		config:  config,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+type retryContextKey struct{}
+
+// Returns a copy of ctx whose requests are only retried until retryCtx is done.
+// Lets an attempt that was started finish after retryCtx is cancelled, e.g. a download when a run is interrupted,
+// without the retries & their backoff keeping it going.
+func WithRetryContext(ctx context.Context, retryCtx context.Context) context.Context {
+	return context.WithValue(ctx, retryContextKey{}, retryCtx)
+}
+
+// The context that stops r's retries, r's own unless WithRetryContext set one
+func retryContext(r *http.Request) context.Context {
+	if ctx, ok := r.Context().Value(retryContextKey{}).(context.Context); ok {
+		return ctx
+	}
+	return r.Context()
+}
+
+// Sends r once its host's rate limit allows it, retrying on transport errors, 429 & 5xx.
+// Once the retries run out, or Retry-After asks for longer than MaxDelay, the last response or error is returned as is.
+// Retries stop once r's context, or the one given to WithRetryContext, is done.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	b := t.bucket(r.URL.Hostname())
+	retryCtx := retryContext(r)
+
+	for attempt := 0; ; attempt++ {
+		waitCtx := r.Context()
+		if attempt > 0 {
+			waitCtx = retryCtx
+		}
+		if err := b.wait(waitCtx); err != nil {
+			return nil, err
+		}
+
+		request := r
+		if attempt > 0 && r.Body != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request = r.Clone(r.Context())
+			request.Body = body
+		}
+
+		response, err := t.base.RoundTrip(request)
+
+		canRetry := attempt < t.config.MaxRetries && (r.Body == nil || r.GetBody != nil)
+		if !canRetry || r.Context().Err() != nil || retryCtx.Err() != nil || !shouldRetry(response, err) {
+			return response, err
+		}
+
+		delay := t.backoff(attempt)
+		if response != nil {
+			if retryAfter, ok := parseRetryAfter(response.Header.Get("Retry-After")); ok {
+				if retryAfter > t.config.MaxDelay {
+					return response, err
+				}
+				delay = retryAfter
+				// Every request to the host has to wait, not just this one
+				b.pause(retryAfter)
+			}
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		if err := sleep(retryCtx, delay); err != nil {
+			return nil, err
+		}
+		if err := r.Context().Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *Transport) bucket(host string) *bucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.buckets[host]
+	if !ok {
+		limit, ok := t.config.Limits[host]
+		if !ok {
+			limit = t.config.DefaultLimit
+		}
+		b = newBucket(limit)
+		t.buckets[host] = b
+	}
+	return b
+}
+
+// Full jitter: a random delay up to BaseDelay * 2^attempt, capped at MaxDelay
+func (t *Transport) backoff(attempt int) time.Duration {
+	delay := t.config.BaseDelay << uint(attempt)
+	if delay > t.config.MaxDelay || delay <= 0 {
+		delay = t.config.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+func shouldRetry(response *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		delay := time.Until(t)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// A base transport answering with statuses in order, then 200s, with retryAfter as every response's Retry-After
+func scripted(attempts *int32, retryAfter string, statuses ...int) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		n := int(atomic.AddInt32(attempts, 1))
+		status := http.StatusOK
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		header := make(http.Header)
+		if retryAfter != "" {
+			header.Set("Retry-After", retryAfter)
+		}
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+	})
+}
+
+var fastConfig = Config{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+func get(t *testing.T, rt http.RoundTripper) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest("GET", "http://vsco.test/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := rt.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestRoundTripRetries(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		retryAfter string
+		statuses   []int
+		status     int
+		attempts   int32
+	}{
+		{"succeeds after errors", "", []int{503, 429, 500}, 200, 4},
+		{"gives up after MaxRetries", "", []int{503, 503, 503, 503, 503}, 503, 4},
+		{"does not retry client errors", "", []int{404}, 404, 1},
+		{"waits out a short Retry-After", "0", []int{429}, 200, 2},
+		{"returns a Retry-After longer than MaxDelay", "86400", []int{429}, 429, 1},
+	} {
+		var attempts int32
+		response := get(t, New(scripted(&attempts, c.retryAfter, c.statuses...), fastConfig))
+		if response.StatusCode != c.status || attempts != c.attempts {
+			t.Errorf("%s: status %d after %d attempts, want %d after %d", c.name, response.StatusCode, attempts, c.status, c.attempts)
+		}
+	}
+}
+
+func TestRoundTripDoesNotPauseHostForLongRetryAfter(t *testing.T) {
+	var attempts int32
+	rt := New(scripted(&attempts, "3600", 429), fastConfig)
+	get(t, rt)
+
+	start := time.Now()
+	if response := get(t, rt); response.StatusCode != 200 || time.Since(start) > time.Second {
+		t.Errorf("next request: status %d after %s, want 200 straight away", response.StatusCode, time.Since(start))
+	}
+}
+
+func TestRoundTripRetriesTransportErrorsUnlessCancelled(t *testing.T) {
+	var attempts int32
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, errors.New("connection reset")
+	})
+
+	request, _ := http.NewRequest("GET", "http://vsco.test/", nil)
+	if _, err := New(base, fastConfig).RoundTrip(request); err == nil || attempts != 4 {
+		t.Errorf("err = %v after %d attempts, want the transport error after 4", err, attempts)
+	}
+
+	attempts = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request, _ = http.NewRequestWithContext(ctx, "GET", "http://vsco.test/", nil)
+	if _, err := New(base, fastConfig).RoundTrip(request); err == nil || attempts != 1 {
+		t.Errorf("err = %v after %d attempts with a cancelled context, want no retries", err, attempts)
+	}
+}
+
+func TestRoundTripStopsRetryingOnceRetryContextIsDone(t *testing.T) {
+	slowConfig := Config{MaxRetries: 10, BaseDelay: time.Minute, MaxDelay: time.Minute}
+
+	// Cancelled during the first attempt, which still gets its response
+	var attempts int32
+	retryCtx, cancel := context.WithCancel(context.Background())
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		cancel()
+		return scripted(&attempts, "", 503).RoundTrip(r)
+	})
+	request, _ := http.NewRequestWithContext(WithRetryContext(context.Background(), retryCtx), "GET", "http://vsco.test/", nil)
+	response, err := New(base, slowConfig).RoundTrip(request)
+	if err != nil || response.StatusCode != 503 || attempts != 1 {
+		t.Errorf("status %v, %v after %d attempts, want 503 after 1", response, err, attempts)
+	}
+
+	// Cancelled during the backoff before a retry
+	attempts = 0
+	retryCtx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	request, _ = http.NewRequestWithContext(WithRetryContext(context.Background(), retryCtx), "GET", "http://vsco.test/", nil)
+	start := time.Now()
+	if _, err := New(scripted(&attempts, "", 503), slowConfig).RoundTrip(request); !errors.Is(err, context.Canceled) || attempts != 1 || time.Since(start) > time.Second {
+		t.Errorf("err = %v after %d attempts & %s, want cancelled after 1 straight away", err, attempts, time.Since(start))
+	}
+}
+
+func TestRoundTripOnlyRetriesReplayableBodies(t *testing.T) {
+	var attempts int32
+	request, _ := http.NewRequest("POST", "http://vsco.test/", io.NopCloser(strings.NewReader("body")))
+	request.GetBody = nil
+	response, err := New(scripted(&attempts, "", 503), fastConfig).RoundTrip(request)
+	if err != nil || response.StatusCode != 503 || attempts != 1 {
+		t.Errorf("status %v, %v after %d attempts, want 503 after 1", response, err, attempts)
+	}
+
+	attempts = 0
+	request, _ = http.NewRequest("POST", "http://vsco.test/", strings.NewReader("body"))
+	response, err = New(scripted(&attempts, "", 503), fastConfig).RoundTrip(request)
+	if err != nil || response.StatusCode != 200 || attempts != 2 {
+		t.Errorf("status %v, %v after %d attempts, want 200 after 2", response, err, attempts)
+	}
+}
+
+func TestBackoffIsJitteredAndCapped(t *testing.T) {
+	tr := New(nil, Config{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+	for attempt := 0; attempt < 70; attempt++ {
+		limit := time.Second
+		if attempt < 4 {
+			limit = 100 * time.Millisecond << uint(attempt)
+		}
+
+		distinct := make(map[time.Duration]struct{})
+		for k := 0; k < 50; k++ {
+			delay := tr.backoff(attempt)
+			if delay < 0 || delay > limit {
+				t.Fatalf("backoff(%d) = %s, want up to %s", attempt, delay, limit)
+			}
+			distinct[delay] = struct{}{}
+		}
+		if len(distinct) < 2 {
+			t.Errorf("backoff(%d) is always %v, want it jittered", attempt, distinct)
+		}
+	}
+
+	if delay := New(nil, Config{}).backoff(3); delay != 0 {
+		t.Errorf("backoff without delays = %s, want 0", delay)
+	}
+}
+
+func TestBucketLimitsRate(t *testing.T) {
+	b := newBucket(Limit{Rate: 10, Burst: 2})
+	if b.take() != 0 || b.take() != 0 {
+		t.Fatal("burst of 2 was not let through")
+	}
+	if delay := b.take(); delay <= 0 || delay > 100*time.Millisecond {
+		t.Errorf("third request waits %s, want up to 100ms", delay)
+	}
+
+	start := time.Now()
+	if err := b.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("waited %s for a token, want about 100ms", elapsed)
+	}
+
+	unlimited := newBucket(Limit{})
+	for k := 0; k < 100; k++ {
+		if delay := unlimited.take(); delay != 0 {
+			t.Fatalf("unlimited bucket waits %s", delay)
+		}
+	}
+}
+
+func TestBucketPause(t *testing.T) {
+	b := newBucket(Limit{})
+	b.pause(time.Hour)
+	b.pause(time.Minute)
+	if delay := b.take(); delay < 59*time.Minute {
+		t.Errorf("paused bucket waits %s, want the longest pause", delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait on a paused bucket = %v, want the context's error", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+		ok    bool
+	}{
+		{"120", 120 * time.Second, 120 * time.Second, true},
+		{"0", 0, 0, true},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 59 * time.Minute, time.Hour, true},
+		{"Sun, 06 Nov 1994 08:49:37 GMT", 0, 0, true},
+		{"Sunday, 06-Nov-94 08:49:37 GMT", 0, 0, true},
+		{"", 0, 0, false},
+		{"-5", 0, 0, false},
+		{"soon", 0, 0, false},
+	} {
+		delay, ok := parseRetryAfter(c.value)
+		if ok != c.ok || delay < c.min || delay > c.max {
+			t.Errorf("parseRetryAfter(%q) = %s, %v, want %s-%s, %v", c.value, delay, ok, c.min, c.max, c.ok)
+		}
+	}
+}
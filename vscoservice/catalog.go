@@ -0,0 +1,286 @@
+package vscoservice
+
+import (
+	"Totem/catalog"
+	"Totem/service"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// The account's records in the catalog
+func (v *VSCOService) catalogRef() catalog.AccountRef {
+	return catalog.AccountRef{Target: filepath.Base(v.TargetDir), Platform: Platform, Username: v.account.Username}
+}
+
+// Records the account & its site in the catalog
+func (v *VSCOService) syncAccount() error {
+	if v.catalog == nil {
+		return nil
+	}
+
+	ref := v.catalogRef()
+	err := v.catalog.PutAccount(catalog.Account{AccountRef: ref, IDs: v.account.IDs, Status: v.account.Status, Created: v.account.Created})
+	if err == nil && v.Site.Name != "" {
+		err = v.catalog.PutSite(catalogSite(ref, SiteRecord{v.Site, time.Now().Unix()}))
+	}
+	if err != nil {
+		return storageError("update catalog", err)
+	}
+	return nil
+}
+
+func catalogSite(ref catalog.AccountRef, r SiteRecord) catalog.Site {
+	return catalog.Site{
+		AccountRef:      ref,
+		ID:              strconv.Itoa(r.Site.SiteID),
+		Name:            r.Site.Name,
+		Description:     r.Site.Description,
+		ProfileImageID:  r.Site.ProfileImageID,
+		ProfileImageURL: r.Site.ProfileImageURL,
+		Seen:            r.Seen,
+	}
+}
+
+// Replaces the account's bios in the catalog with records
+func (v *VSCOService) syncBios(records []BioRecord) error {
+	if v.catalog == nil {
+		return nil
+	}
+
+	ref := v.catalogRef()
+	bios := make([]catalog.Bio, len(records))
+	for i, r := range records {
+		bios[i] = catalog.Bio{AccountRef: ref, Description: r.Description, Recorded: r.CaptureDate}
+	}
+	if err := v.catalog.SetBios(ref, bios); err != nil {
+		return storageError("update catalog", err)
+	}
+	return nil
+}
+
+// Replaces the account's profile images in the catalog with records
+func (v *VSCOService) syncProfileImages(records []ProfileImageRecord) error {
+	if v.catalog == nil {
+		return nil
+	}
+
+	ref := v.catalogRef()
+	images := make([]catalog.ProfileImage, len(records))
+	for i, r := range records {
+		images[i] = catalog.ProfileImage{
+			AccountRef: ref,
+			ImageID:    r.ImageID,
+			File:       v.ProfileDir + "/" + r.ImageID + ".jpg",
+			SHA256:     r.SHA256,
+			FirstSeen:  r.FirstSeen,
+			LastSeen:   r.LastSeen,
+		}
+	}
+	if err := v.catalog.SetProfileImages(ref, images); err != nil {
+		return storageError("update catalog", err)
+	}
+	return nil
+}
+
+// Appends events to the account's history & adds them to the catalog
+func (v *VSCOService) appendHistory(events ...service.Event) error {
+	if err := service.AppendHistory(v.AccountDir, events...); err != nil {
+		return err
+	}
+	return v.syncEvents(events)
+}
+
+func (v *VSCOService) syncEvents(events []service.Event) error {
+	if v.catalog == nil {
+		return nil
+	}
+
+	ref := v.catalogRef()
+	records := make([]catalog.Event, len(events))
+	for i, e := range events {
+		records[i] = catalog.Event{
+			AccountRef:  ref,
+			Kind:        e.Kind,
+			Time:        e.Time,
+			Source:      e.Source,
+			MediaID:     e.MediaID,
+			Changes:     e.Changes,
+			Description: e.Description(),
+		}
+	}
+	if err := v.catalog.AddEvents(records...); err != nil {
+		return storageError("update catalog", err)
+	}
+	return nil
+}
+
+// Records the media in ids, stored in c.dir, in the catalog using their Info.json & Metadata.json.
+// Media whose Info.json is missing is left out.
+func (v *VSCOService) syncMedia(c mediaCollector, index MediaIndex, ids map[string]struct{}) error {
+	if v.catalog == nil || len(ids) == 0 {
+		return nil
+	}
+
+	ref := v.catalogRef()
+	var media []catalog.Media
+	for id := range ids {
+		im, ok := index.Media[id]
+		if !ok {
+			continue
+		}
+
+		dir := c.dir + "/" + im.Filename
+		var m VSCOMedia
+		bytes, err := os.ReadFile(dir + "/Info.json")
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return storageError("read media info "+id, err)
+		}
+		if err := json.Unmarshal(bytes, &m); err != nil {
+			return storageError("decode media info "+id, err)
+		}
+
+		cm := catalogMedia(ref, c.source, dir, m, im)
+		mm, ok, err := readMediaMetadata(dir, id)
+		if err != nil {
+			return err
+		} else if ok {
+			addFileMetadata(&cm, mm, m)
+		}
+		media = append(media, cm)
+	}
+
+	if err := v.catalog.PutMedia(media...); err != nil {
+		return storageError("update catalog", err)
+	}
+	return nil
+}
+
+func catalogMedia(ref catalog.AccountRef, source string, dir string, m VSCOMedia, im IndexedMedia) catalog.Media {
+	cm := catalog.Media{
+		AccountRef:  ref,
+		Source:      source,
+		ID:          m.ID,
+		Dir:         dir,
+		File:        dir + "/" + mediaFileName(m),
+		SHA256:      im.SHA256,
+		Description: m.Description,
+		IsVideo:     m.IsVideo,
+		Captured:    m.CaptureDate,
+		Uploaded:    m.UploadDate,
+		Collected:   m.CollectedDate,
+		Updated:     m.LastUpdated,
+		Deleted:     im.Deleted,
+		Make:        m.ImageMetadata.Make,
+		Model:       m.ImageMetadata.Model,
+	}
+	for _, t := range m.Tags {
+		cm.Tags = append(cm.Tags, catalog.Tag{Text: t.Text, Slug: t.Slug})
+	}
+
+	// VSCO lists coordinates longitude first
+	if m.HasLocation && len(m.LocationCoordinates) == 2 {
+		cm.HasLocation = true
+		cm.Longitude, cm.Latitude = m.LocationCoordinates[0], m.LocationCoordinates[1]
+	}
+	return cm
+}
+
+// Writes the site to Profile/.Site.json, so the catalog can be rebuilt with it
+func (v *VSCOService) writeSiteRecord() error {
+	bytes, err := json.Marshal(SiteRecord{v.Site, time.Now().Unix()})
+	if err != nil {
+		return storageError("encode site record", err)
+	}
+
+	err = os.WriteFile(v.ProfileDir+"/.Site.json", bytes, os.ModePerm)
+	if err != nil {
+		return storageError("write site record", err)
+	}
+	return nil
+}
+
+// Rebuilds the account's records in the catalog from what is stored on disk
+func (v *VSCOService) Reindex() error {
+	if v.catalog == nil {
+		return nil
+	}
+
+	ref := v.catalogRef()
+	if err := v.catalog.RemoveAccount(ref); err != nil {
+		return storageError("update catalog", err)
+	}
+	if err := v.syncAccount(); err != nil {
+		return err
+	}
+
+	var site SiteRecord
+	if bytes, err := os.ReadFile(v.ProfileDir + "/.Site.json"); err == nil {
+		if err := json.Unmarshal(bytes, &site); err != nil {
+			return storageError("decode site record", err)
+		}
+		if err := v.catalog.PutSite(catalogSite(ref, site)); err != nil {
+			return storageError("update catalog", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return storageError("read site record", err)
+	}
+
+	var bios []BioRecord
+	if bytes, err := os.ReadFile(v.ProfileDir + "/.BioRecord.json"); err == nil {
+		if err := json.Unmarshal(bytes, &bios); err != nil {
+			return storageError("decode bio record", err)
+		}
+		if err := v.syncBios(bios); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return storageError("read bio record", err)
+	}
+
+	if _, err := os.Stat(v.ProfileDir); err == nil {
+		images, err := v.readProfileImageRecords()
+		if err != nil {
+			return err
+		}
+		if err := v.syncProfileImages(images); err != nil {
+			return err
+		}
+	}
+
+	events, err := service.ReadHistory(v.AccountDir)
+	if err != nil {
+		return err
+	}
+	if err := v.syncEvents(events); err != nil {
+		return err
+	}
+
+	for _, source := range v.MediaSources() {
+		c, err := v.collectorFor(source)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(c.dir); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		index, err := readMediaIndex(c.dir, c.mediaTime)
+		if err != nil {
+			return err
+		}
+		ids := make(map[string]struct{}, len(index.Media))
+		for id := range index.Media {
+			ids[id] = struct{}{}
+		}
+		if err := v.syncMedia(c, index, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
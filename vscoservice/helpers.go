@@ -1,23 +1,30 @@
 package vscoservice
 
 import (
+	"Totem/service"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
 // MISC
 
-func getEntriesDictForDir(dir string) map[string]struct{} {
+func getEntriesDictForDir(dir string) (map[string]struct{}, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
-		panic(err)
+		return nil, storageError("read "+dir, err)
 	}
 	s := make(map[string]struct{})
 	for _, e := range entries {
 		s[e.Name()] = struct{}{}
 	}
-	return s
+	return s, nil
 }
 
 func isInEntries(entry string, entries map[string]struct{}) bool {
@@ -27,12 +34,56 @@ func isInEntries(entry string, entries map[string]struct{}) bool {
 	return false
 }
 
+// The name of the directory media is stored in, e.g. 20200913T122640Z_5f5e1a2b3c.
+// The UTC time keeps directories in order & the media ID keeps media uploaded in the same second apart.
+func mediaDirName(t time.Time, id string) string {
+	return t.UTC().Format("20060102T150405Z") + "_" + id
+}
+
 func parseMilliTimestamp(tm int64) time.Time {
 	sec := tm / 1000
 	msec := tm % 1000
 	return time.Unix(sec, msec*int64(time.Millisecond))
 }
 
+// Creates dir if it does not exist
+func mkdirIfNotExist(dir string) error {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		err := os.Mkdir(dir, os.ModePerm)
+		if err != nil {
+			return storageError("create "+dir, err)
+		}
+	}
+	return nil
+}
+
+// ERRORS
+
+func storageError(op string, err error) error {
+	return &service.Error{Kind: service.ErrStorage, Op: op, Err: err}
+}
+
+func decodeError(op string, err error) error {
+	return &service.Error{Kind: service.ErrDecode, Op: op, Err: err}
+}
+
+func transportError(op string, err error) error {
+	return &service.Error{Kind: service.ErrTransport, Op: op, Err: err}
+}
+
+// Converts an unsuccessful response status into an error
+func statusError(op string, response *http.Response) error {
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return &service.Error{Kind: service.ErrNotFound, Op: op}
+	case response.StatusCode == http.StatusTooManyRequests:
+		return &service.Error{Kind: service.ErrRateLimited, Op: op}
+	case response.StatusCode >= 400:
+		return transportError(op, fmt.Errorf("unexpected status %s", response.Status))
+	}
+	return nil
+}
+
 // REQUEST
 
 // A request for static data
@@ -52,3 +103,82 @@ func setMediaRequestHeaders(request *http.Request) {
 	request.Header.Set("X-Client-Build", "1")
 	request.Header.Set("X-Client-Platform", "web")
 }
+
+// Sends request with the service's client & returns the response body
+func (v *VSCOService) doRequest(op string, request *http.Request) ([]byte, error) {
+	_, body, err := v.send(op, request)
+	return body, err
+}
+
+// Sends request & returns the response along with its body
+func (v *VSCOService) send(op string, request *http.Request) (*http.Response, []byte, error) {
+	response, err := v.httpClient.Do(request)
+	if err != nil {
+		return nil, nil, transportError(op, err)
+	}
+	defer response.Body.Close()
+
+	if err := statusError(op, response); err != nil {
+		return nil, nil, err
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, transportError(op, err)
+	}
+	return response, body, nil
+}
+
+// Sends a request for an image or video & checks that the body really is one,
+// so error pages are not saved as media.
+func (v *VSCOService) doMediaRequest(op string, request *http.Request, isVideo bool) ([]byte, error) {
+	response, body, err := v.send(op, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		return nil, transportError(op, errors.New("empty body"))
+	}
+	if response.ContentLength >= 0 && int64(len(body)) != response.ContentLength {
+		return nil, transportError(op, fmt.Errorf("got %d bytes, Content-Length is %d", len(body), response.ContentLength))
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+
+	expected := "image/"
+	if isVideo {
+		expected = "video/"
+	}
+	if !strings.HasPrefix(mediaType, expected) && mediaType != "application/octet-stream" {
+		return nil, transportError(op, fmt.Errorf("unexpected Content-Type %s", contentType))
+	}
+	return body, nil
+}
+
+// Sends request & decodes the JSON response body into data
+func (v *VSCOService) doJSONRequest(op string, request *http.Request, data interface{}) error {
+	body, err := v.doRequest(op, request)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(body, data)
+	if err != nil {
+		return decodeError(op, err)
+	}
+	return nil
+}
+
+// Carries the values of the context it wraps but is never cancelled
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
@@ -0,0 +1,126 @@
+package vscoservice
+
+import (
+	"Totem/service"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reads the index of a media source directory.
+// Archives from before the index existed are indexed from their Info.json files, & marked incomplete so they get a full scan.
+func readMediaIndex(dir string, mediaTime func(VSCOMedia) time.Time) (MediaIndex, error) {
+	index := MediaIndex{Media: make(map[string]IndexedMedia)}
+
+	bytes, err := os.ReadFile(dir + "/.Index.json")
+	if err == nil {
+		err = json.Unmarshal(bytes, &index)
+		if err != nil {
+			return index, storageError("decode media index", err)
+		}
+		if index.Media == nil {
+			index.Media = make(map[string]IndexedMedia)
+		}
+		return index, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return index, storageError("read media index", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return index, storageError("read "+dir, err)
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		var m VSCOMedia
+		bytes, err := os.ReadFile(dir + "/" + e.Name() + "/Info.json")
+		if err != nil || json.Unmarshal(bytes, &m) != nil || m.ID == "" {
+			continue
+		}
+		index.Media[m.ID] = indexMedia(e.Name(), m, mediaTime)
+	}
+	return index, nil
+}
+
+// The index entry for m, stored in the directory filename
+func indexMedia(filename string, m VSCOMedia, mediaTime func(VSCOMedia) time.Time) IndexedMedia {
+	return IndexedMedia{
+		Filename:    filename,
+		Time:        mediaTime(m).UnixNano() / int64(time.Millisecond),
+		Uploaded:    m.UploadDate,
+		Collected:   m.CollectedDate,
+		Fingerprint: mediaFingerprint(m),
+	}
+}
+
+func writeMediaIndex(dir string, index MediaIndex) error {
+	bytes, err := json.Marshal(index)
+	if err != nil {
+		return storageError("encode media index", err)
+	}
+
+	err = os.WriteFile(dir+"/.Index.json", bytes, os.ModePerm)
+	if err != nil {
+		return storageError("write media index", err)
+	}
+	return nil
+}
+
+// The newest media time in the index, in milliseconds
+func (index MediaIndex) newest() int64 {
+	var newest int64
+	for _, m := range index.Media {
+		if m.Time > newest {
+			newest = m.Time
+		}
+	}
+	return newest
+}
+
+// The media stored in source, oldest first
+func (v *VSCOService) StoredMedia(source service.MediaSource) ([]service.StoredMedia, error) {
+	c, err := v.collectorFor(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(c.dir); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	index, err := readMediaIndex(c.dir, c.mediaTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var media []service.StoredMedia
+	for id, im := range index.Media {
+		dir := c.dir + "/" + im.Filename
+		sm := service.StoredMedia{
+			ID:        id,
+			Dir:       dir,
+			SHA256:    im.SHA256,
+			Uploaded:  im.Uploaded,
+			Collected: im.Collected,
+			Deleted:   im.Deleted,
+		}
+		for _, name := range []string{id + ".jpg", id + ".mp4"} {
+			if _, err := os.Stat(dir + "/" + name); err == nil {
+				sm.File = dir + "/" + name
+				break
+			}
+		}
+		media = append(media, sm)
+	}
+
+	sort.Slice(media, func(i, j int) bool {
+		return media[i].Dir < media[j].Dir
+	})
+	return media, nil
+}
@@ -0,0 +1,121 @@
+package vscoservice
+
+import (
+	"Totem/blobstore"
+	"Totem/service"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Re-keys the media stored in source from each media's Info.json.
+// Directories under older names, e.g. "Mon, Jan 2, 15h04m05s, MST 2006", are renamed to mediaDirName
+// & the index's times are refreshed. Media files are moved into the blob store if there is one
+// & media archived before Metadata.json was written get one.
+// Nothing is downloaded.
+func (v *VSCOService) MigrateLayout(source service.MediaSource) (int, error) {
+	c, err := v.collectorFor(source)
+	if err != nil {
+		return 0, err
+	}
+	return v.migrateMediaDirs(c)
+}
+
+// Renames the media directories in c.dir that are not named by mediaDirName & updates the index to match.
+// Directories without a readable Info.json are left as they are, as are ones whose new name is already taken.
+func (v *VSCOService) migrateMediaDirs(c mediaCollector) (int, error) {
+	entries, err := os.ReadDir(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, storageError("read "+c.dir, err)
+	}
+
+	index, err := readMediaIndex(c.dir, c.mediaTime)
+	if err != nil {
+		return 0, err
+	}
+
+	var renamed int
+	var migrateErr error
+	migrated := make(map[string]struct{})
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		var m VSCOMedia
+		bytes, err := os.ReadFile(c.dir + "/" + e.Name() + "/Info.json")
+		if err != nil || json.Unmarshal(bytes, &m) != nil || m.ID == "" {
+			continue
+		}
+
+		name := mediaDirName(c.mediaTime(m), m.ID)
+		if name != e.Name() {
+			if _, err := os.Stat(c.dir + "/" + name); !errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err := os.Rename(c.dir+"/"+e.Name(), c.dir+"/"+name); err != nil {
+				migrateErr = storageError("rename "+e.Name(), err)
+				break
+			}
+			renamed++
+		}
+
+		// Keep what scans recorded about the media, only its name & times come from Info.json
+		im := indexMedia(name, m, c.mediaTime)
+		if old, ok := index.Media[m.ID]; ok {
+			im.LastSeen, im.Deleted, im.Fingerprint, im.SHA256 = old.LastSeen, old.Deleted, old.Fingerprint, old.SHA256
+		}
+
+		sum, err := v.hashMediaFile(c.dir+"/"+name+"/"+mediaFileName(m), im.SHA256)
+		if err != nil {
+			migrateErr = err
+			index.Media[m.ID] = im
+			break
+		}
+		im.SHA256 = sum
+		index.Media[m.ID] = im
+
+		if err := backfillMediaMetadata(c.dir+"/"+name, m); err != nil {
+			migrateErr = err
+			break
+		}
+		migrated[m.ID] = struct{}{}
+	}
+
+	if err := writeMediaIndex(c.dir, index); err != nil {
+		return renamed, err
+	}
+	if err := v.syncMedia(c, index, migrated); err != nil {
+		return renamed, err
+	}
+	return renamed, migrateErr
+}
+
+// Moves file into the blob store if there is one, returning its SHA-256.
+// Without a blob store file is only hashed if sum, the hash recorded for it, is empty.
+// Returns an empty sum if file is missing.
+func (v *VSCOService) hashMediaFile(file string, sum string) (string, error) {
+	if v.blobs != nil {
+		sum, err := v.blobs.Adopt(file)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		} else if err != nil {
+			return "", storageError("store "+file, err)
+		}
+		return sum, nil
+	}
+
+	if sum != "" {
+		return sum, nil
+	}
+	bytes, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", storageError("read "+file, err)
+	}
+	return blobstore.Sum(bytes), nil
+}
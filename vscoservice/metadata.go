@@ -0,0 +1,88 @@
+package vscoservice
+
+import (
+	"Totem/catalog"
+	"Totem/mediameta"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// Reads the metadata of data, the image or video of media id, & writes it to Metadata.json in mediaDir.
+// Metadata that cannot be read is recorded in Metadata.json rather than failing the media.
+func writeMediaMetadata(mediaDir string, id string, data []byte) error {
+	meta, err := mediameta.Extract(data)
+	mm := MediaMetadata{Metadata: meta}
+	if err != nil {
+		mm.Error = err.Error()
+	}
+
+	bytes, err := json.Marshal(mm)
+	if err != nil {
+		return storageError("encode media metadata "+id, err)
+	}
+	if err := os.WriteFile(mediaDir+"/Metadata.json", bytes, os.ModePerm); err != nil {
+		return storageError("write media metadata "+id, err)
+	}
+	return nil
+}
+
+// Writes Metadata.json for the media stored in mediaDir from its file, unless it already has one or its file is missing
+func backfillMediaMetadata(mediaDir string, m VSCOMedia) error {
+	if _, err := os.Stat(mediaDir + "/Metadata.json"); !errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	bytes, err := os.ReadFile(mediaDir + "/" + mediaFileName(m))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return storageError("read media "+m.ID, err)
+	}
+	return writeMediaMetadata(mediaDir, m.ID, bytes)
+}
+
+// Reads Metadata.json in mediaDir, ok is false if there is none
+func readMediaMetadata(mediaDir string, id string) (mm MediaMetadata, ok bool, err error) {
+	bytes, err := os.ReadFile(mediaDir + "/Metadata.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return mm, false, nil
+	} else if err != nil {
+		return mm, false, storageError("read media metadata "+id, err)
+	}
+	if err := json.Unmarshal(bytes, &mm); err != nil {
+		return mm, false, storageError("decode media metadata "+id, err)
+	}
+	return mm, true, nil
+}
+
+// What VSCO says about m, to compare with its file's metadata
+func expectedMetadata(m VSCOMedia) mediameta.Expected {
+	e := mediameta.Expected{
+		Make:     m.ImageMetadata.Make,
+		Model:    m.ImageMetadata.Model,
+		Captured: m.CaptureDate,
+	}
+	// VSCO lists coordinates longitude first
+	if m.HasLocation && len(m.LocationCoordinates) == 2 {
+		e.HasLocation = true
+		e.Longitude, e.Latitude = m.LocationCoordinates[0], m.LocationCoordinates[1]
+	}
+	return e
+}
+
+// Adds the file's metadata to cm, along with where it disagrees with m
+func addFileMetadata(cm *catalog.Media, mm MediaMetadata, m VSCOMedia) {
+	if mm.Format == "" {
+		return
+	}
+	cm.FileMetadata = &catalog.FileMetadata{
+		Make:        mm.Make,
+		Model:       mm.Model,
+		Software:    mm.Software,
+		Captured:    mm.Captured,
+		HasLocation: mm.HasLocation,
+		Latitude:    mm.Latitude,
+		Longitude:   mm.Longitude,
+	}
+	cm.Discrepancies = mm.Discrepancies(expectedMetadata(m))
+}
@@ -1,6 +1,9 @@
 package vscoservice
 
-import "sync"
+import (
+	"Totem/mediameta"
+	"sync"
+)
 
 //// VSCO
 
@@ -47,31 +50,73 @@ type VSCOMedia struct {
 
 //// INTERNAl
 
-// VSCO tracking profile:
-// - Multiple accounts can be owned by one person
-// - You can reserve usernames in case that person creates an account
-// - The SiteID's get saved, in case the person changes their name
-type VSCOTrackingProfile struct {
-	// The name of the person's whose accounts you are tracking
-	TargetName string `json:"target_name"`
+type MediaData struct {
+	Bytes     []byte
+	Filename  string
+	VSCOMedia VSCOMedia
 
-	// Currently tracking this target
-	Active bool
+	// Set if the media could not be downloaded
+	Err error
+}
 
-	// Accounts you are tracking.
-	Accounts []VSCOAccount
+// A media that failed to download, retried on the next run
+type FailedDownload struct {
+	Media       VSCOMedia `json:"media"`
+	Error       string    `json:"error"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt int64     `json:"last_attempt"`
 }
 
-type VSCOAccount struct {
-	UserID   int    `json:"user_id"`
-	SiteID   int    `json:"site_id"`
-	Username string `json:"username"`
+// The media archived in a media source directory, stored in {dir}/.Index.json
+type MediaIndex struct {
+	// Set once a scan has paged through all of the media.
+	// Scans only stop early at archived media if the scan before them was complete.
+	Complete bool `json:"complete"`
+
+	// By media ID
+	Media map[string]IndexedMedia `json:"media"`
 }
 
-type MediaData struct {
-	Bytes     []byte
-	Filename  string
-	VSCOMedia VSCOMedia
+type IndexedMedia struct {
+	// The media's directory
+	Filename string `json:"filename"`
+
+	// The time the media's directory is named after, in milliseconds
+	Time int64 `json:"time"`
+
+	// When the media was uploaded & when it was added to the collection, in milliseconds.
+	// Collected is 0 for gallery media.
+	Uploaded  int64 `json:"uploaded"`
+	Collected int64 `json:"collected,omitempty"`
+
+	// When a scan last saw the media on VSCO, 0 if no scan has since it was indexed
+	LastSeen int64 `json:"last_seen"`
+
+	// When a complete scan first noticed the media was gone, 0 if it is still on VSCO
+	Deleted int64 `json:"deleted,omitempty"`
+
+	// Hash of the media's editable fields when Info.json was last written
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Hex SHA-256 of the image or video, names its blob in the blob store
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// A version of a media's Info.json that was replaced because the media was edited.
+// Kept in Revisions.json next to Info.json, oldest first.
+type MediaRevision struct {
+	// When the edit was noticed
+	Replaced      int64     `json:"replaced"`
+	ChangedFields []string  `json:"changed_fields"`
+	Media         VSCOMedia `json:"media"`
+}
+
+// What a media's image or video says about itself, kept in Metadata.json next to Info.json
+type MediaMetadata struct {
+	mediameta.Metadata
+
+	// Set if the file's metadata could not be read, the rest is what could be
+	Error string `json:"error,omitempty"`
 }
 
 type DataSink struct {
@@ -80,6 +125,23 @@ type DataSink struct {
 	data      chan MediaData
 }
 
+// A profile image the User had, along with when it was seen.
+type ProfileImageRecord struct {
+	ImageID   string `json:"image_id"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+
+	// Where it was downloaded from, empty for images saved before they were recorded
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// The User's site when it was last seen, kept in Profile/.Site.json
+type SiteRecord struct {
+	Site VSCOSite `json:"site"`
+	Seen int64    `json:"seen"`
+}
+
 // The User's bio, along with when it was recorded.
 type BioRecord struct {
 	Description string `json:"description"`
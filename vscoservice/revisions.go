@@ -0,0 +1,100 @@
+package vscoservice
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+)
+
+// The fields of a media that can change after it is posted
+type editableFields struct {
+	Description         string
+	Tags                interface{}
+	LocationCoordinates []float64
+	HasLocation         bool
+	LastUpdated         int64
+}
+
+func editable(m VSCOMedia) editableFields {
+	return editableFields{m.Description, m.Tags, m.LocationCoordinates, m.HasLocation, m.LastUpdated}
+}
+
+// A hash of the media's editable fields, stored in the index to notice edits without reading Info.json
+func mediaFingerprint(m VSCOMedia) string {
+	bytes, _ := json.Marshal(editable(m))
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:8])
+}
+
+// The names of the editable fields that differ between old & new
+func changedFields(old VSCOMedia, new VSCOMedia) []string {
+	var changed []string
+	if old.Description != new.Description {
+		changed = append(changed, "description")
+	}
+	if !reflect.DeepEqual(old.Tags, new.Tags) && (len(old.Tags) > 0 || len(new.Tags) > 0) {
+		changed = append(changed, "tags")
+	}
+	if old.HasLocation != new.HasLocation || !reflect.DeepEqual(old.LocationCoordinates, new.LocationCoordinates) && (len(old.LocationCoordinates) > 0 || len(new.LocationCoordinates) > 0) {
+		changed = append(changed, "location")
+	}
+	if old.LastUpdated != new.LastUpdated {
+		changed = append(changed, "last_updated")
+	}
+	return changed
+}
+
+// Compares m with the Info.json in mediaDir. If it was edited, the old Info.json is added to
+// mediaDir/Revisions.json & replaced with m. Returns the fields that changed.
+func recordRevision(mediaDir string, m VSCOMedia, now int64) ([]string, error) {
+	var stored VSCOMedia
+
+	bytes, err := os.ReadFile(mediaDir + "/Info.json")
+	if err != nil {
+		return nil, storageError("read media info "+m.ID, err)
+	}
+	err = json.Unmarshal(bytes, &stored)
+	if err != nil {
+		return nil, storageError("decode media info "+m.ID, err)
+	}
+
+	changed := changedFields(stored, m)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	// Revisions.json is append-only, so it is written before Info.json is replaced
+	var revisions []MediaRevision
+	bytes, err = os.ReadFile(mediaDir + "/Revisions.json")
+	if err == nil {
+		err = json.Unmarshal(bytes, &revisions)
+		if err != nil {
+			return nil, storageError("decode media revisions "+m.ID, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, storageError("read media revisions "+m.ID, err)
+	}
+	revisions = append(revisions, MediaRevision{now, changed, stored})
+
+	bytes, err = json.Marshal(revisions)
+	if err != nil {
+		return nil, storageError("encode media revisions "+m.ID, err)
+	}
+	err = os.WriteFile(mediaDir+"/Revisions.json", bytes, os.ModePerm)
+	if err != nil {
+		return nil, storageError("write media revisions "+m.ID, err)
+	}
+
+	bytes, err = json.Marshal(m)
+	if err != nil {
+		return nil, storageError("encode media info "+m.ID, err)
+	}
+	err = os.WriteFile(mediaDir+"/Info.json", bytes, os.ModePerm)
+	if err != nil {
+		return nil, storageError("write media info "+m.ID, err)
+	}
+	return changed, nil
+}
@@ -0,0 +1,409 @@
+// Package vscofake is a local fake of the VSCO API endpoints Totem uses.
+//
+// Sites & media are fixtures that can be changed between runs, so tests can script
+// renames, deleted accounts, bio changes & new uploads. Media URLs point back at the fake.
+package vscofake
+
+import (
+	"Totem/vscoservice"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	sites    map[int]*site
+	files    map[string][]byte
+	requests []string
+	failures []failure
+	nextID   int
+
+	// Called before each file is served
+	onFile func(path string)
+}
+
+// Scripted error responses for requests whose path starts with prefix:
+// either status, or a 200 HTML error page if errorPage is set
+type failure struct {
+	prefix    string
+	status    int
+	errorPage bool
+	times     int
+}
+
+type site struct {
+	vscoservice.VSCOSite
+	deleted bool
+
+	// Newest first, like the real API
+	gallery    []vscoservice.VSCOMedia
+	collection []vscoservice.VSCOMedia
+}
+
+// Starts a fake VSCO server, Close it when done
+func NewServer() *Server {
+	s := &Server{
+		sites:  make(map[int]*site),
+		files:  make(map[string][]byte),
+		nextID: 1000,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/sites", s.handleSitesBySubdomain)
+	mux.HandleFunc("/api/2.0/sites/", s.handleSiteByID)
+	mux.HandleFunc("/api/2.0/medias", s.handleGalleryMedia)
+	mux.HandleFunc("/api/2.0/collections/", s.handleCollectionMedia)
+	mux.HandleFunc("/files/", s.handleFile)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.RequestURI())
+		f, failed := s.nextFailure(r.URL.Path)
+		s.mu.Unlock()
+
+		if failed && f.errorPage {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html><body>Something went wrong</body></html>"))
+			return
+		}
+		if failed {
+			if f.status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "0")
+			}
+			http.Error(w, http.StatusText(f.status), f.status)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// The API base URL to give vscoservice.WithBaseURL
+func (s *Server) BaseURL() string {
+	return s.URL + "/api/2.0"
+}
+
+// A client that sends every request to the fake, whatever its scheme & host.
+// VSCO media URLs have no scheme & profile images are fetched over https, so use this client.
+func (s *Server) Client() *http.Client {
+	host := strings.TrimPrefix(s.URL, "http://")
+	return &http.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			r = r.Clone(r.Context())
+			r.URL.Scheme = "http"
+			r.URL.Host = host
+			return http.DefaultTransport.RoundTrip(r)
+		}),
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// The request URIs the fake has received, in order
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// FIXTURES
+
+// Makes the next times requests whose path starts with pathPrefix fail with status.
+// 429 responses ask to be retried immediately.
+func (s *Server) Fail(pathPrefix string, status int, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, failure{prefix: pathPrefix, status: status, times: times})
+}
+
+// Calls f with the path of each file before it is served, e.g. to interrupt a run while it downloads
+func (s *Server) OnFile(f func(path string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onFile = f
+}
+
+// Makes the next times requests whose path starts with pathPrefix get a 200 HTML error page
+func (s *Server) ServeErrorPage(pathPrefix string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, failure{prefix: pathPrefix, errorPage: true, times: times})
+}
+
+// The scripted failure for the next request to path, if there is one
+func (s *Server) nextFailure(path string) (failure, bool) {
+	for i := range s.failures {
+		if s.failures[i].times > 0 && strings.HasPrefix(path, s.failures[i].prefix) {
+			s.failures[i].times--
+			return s.failures[i], true
+		}
+	}
+	return failure{}, false
+}
+
+// Creates a site for username & returns it with its SiteID, UserID & SiteCollectionID filled in
+func (s *Server) AddSite(username string, description string) vscoservice.VSCOSite {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	id := s.nextID
+	vs := vscoservice.VSCOSite{
+		SiteID:           id,
+		SiteCollectionID: "collection" + strconv.Itoa(id),
+		Name:             username,
+		Description:      description,
+		UserID:           id + 500000,
+	}
+	s.sites[id] = &site{VSCOSite: vs}
+	s.setProfileImage(s.sites[id], "avatar"+strconv.Itoa(id), []byte("avatar of "+username))
+
+	return s.sites[id].VSCOSite
+}
+
+// Changes a site's username, the SiteID stays the same
+func (s *Server) RenameSite(siteID int, username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sites[siteID].Name = username
+}
+
+// Deletes a site, it can be restored with RestoreSite
+func (s *Server) DeleteSite(siteID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sites[siteID].deleted = true
+}
+
+func (s *Server) RestoreSite(siteID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sites[siteID].deleted = false
+}
+
+func (s *Server) SetBio(siteID int, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sites[siteID].Description = description
+}
+
+// Replaces a site's profile image
+func (s *Server) SetProfileImage(siteID int, imageID string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setProfileImage(s.sites[siteID], imageID, data)
+}
+
+func (s *Server) setProfileImage(st *site, imageID string, data []byte) {
+	path := "/files/profile/" + imageID + ".jpg"
+	s.files[path] = data
+	st.ProfileImageID = imageID
+	st.ProfileImageURL = s.host() + path
+	st.ResponsiveURL = s.host() + path
+}
+
+// Uploads m to a site's gallery with data as its image or video.
+// Returns m with its SiteID & URLs filled in.
+func (s *Server) AddGalleryMedia(siteID int, m vscoservice.VSCOMedia, data []byte) vscoservice.VSCOMedia {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.sites[siteID]
+	m = s.addFile(m, data)
+	m.SiteID = siteID
+	st.gallery = append([]vscoservice.VSCOMedia{m}, st.gallery...)
+	return m
+}
+
+// Removes a media from a site's gallery
+func (s *Server) RemoveGalleryMedia(siteID int, mediaID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.sites[siteID]
+	for i, m := range st.gallery {
+		if m.ID == mediaID {
+			st.gallery = append(st.gallery[:i], st.gallery[i+1:]...)
+			return
+		}
+	}
+}
+
+// Replaces a media in a site's gallery, keeping its URLs
+func (s *Server) UpdateGalleryMedia(siteID int, m vscoservice.VSCOMedia) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.sites[siteID]
+	for i := range st.gallery {
+		if st.gallery[i].ID == m.ID {
+			m.SiteID, m.ResponsiveURL, m.VideoURL = siteID, st.gallery[i].ResponsiveURL, st.gallery[i].VideoURL
+			st.gallery[i] = m
+			return
+		}
+	}
+}
+
+// Adds m to a site's collection with data as its image or video.
+// m.SiteID should be the site that published it.
+func (s *Server) AddCollectionMedia(siteID int, m vscoservice.VSCOMedia, data []byte) vscoservice.VSCOMedia {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.sites[siteID]
+	m = s.addFile(m, data)
+	st.collection = append([]vscoservice.VSCOMedia{m}, st.collection...)
+	return m
+}
+
+func (s *Server) addFile(m vscoservice.VSCOMedia, data []byte) vscoservice.VSCOMedia {
+	extension := ".jpg"
+	if m.IsVideo {
+		extension = ".mp4"
+	}
+
+	path := "/files/media/" + m.ID + extension
+	s.files[path] = data
+	if m.IsVideo {
+		m.VideoURL = s.host() + path
+	} else {
+		m.ResponsiveURL = s.host() + path
+	}
+	return m
+}
+
+func (s *Server) host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// HANDLERS
+
+// GET /api/2.0/sites?subdomain={username}
+func (s *Server) handleSitesBySubdomain(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sites := []vscoservice.VSCOSite{}
+	for _, st := range s.sites {
+		if !st.deleted && st.Name == r.URL.Query().Get("subdomain") {
+			sites = append(sites, st.VSCOSite)
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{"sites": sites})
+}
+
+// GET /api/2.0/sites/{id}
+func (s *Server) handleSiteByID(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/2.0/sites/"))
+	st, ok := s.sites[id]
+	if !ok || st.deleted {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"site": st.VSCOSite})
+}
+
+// GET /api/2.0/medias?site_id={id}&size={size}&page={page}
+func (s *Server) handleGalleryMedia(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, _ := strconv.Atoi(r.URL.Query().Get("site_id"))
+	st, ok := s.sites[id]
+	if !ok || st.deleted {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"media": page(st.gallery, r)})
+}
+
+// GET /api/2.0/collections/{collection id}/medias?size={size}&page={page}
+func (s *Server) handleCollectionMedia(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	collectionID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/2.0/collections/"), "/medias")
+	for _, st := range s.sites {
+		if st.SiteCollectionID == collectionID && !st.deleted {
+			writeJSON(w, map[string]interface{}{"medias": page(st.collection, r)})
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// GET /files/...
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, ok := s.files[r.URL.Path]
+	onFile := s.onFile
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if onFile != nil {
+		onFile(r.URL.Path)
+	}
+
+	if strings.HasSuffix(r.URL.Path, ".mp4") {
+		w.Header().Set("Content-Type", "video/mp4")
+	} else {
+		w.Header().Set("Content-Type", "image/jpeg")
+	}
+	w.Write(data)
+}
+
+// The page of media requested by r's size & page query, pages start at 1
+func page(media []vscoservice.VSCOMedia, r *http.Request) []vscoservice.VSCOMedia {
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size < 1 {
+		size = 30
+	}
+	p, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || p < 1 {
+		p = 1
+	}
+
+	start := (p - 1) * size
+	if start >= len(media) {
+		return []vscoservice.VSCOMedia{}
+	}
+	end := start + size
+	if end > len(media) {
+		end = len(media)
+	}
+	return media[start:end]
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,13 +1,21 @@
 package vscoservice
 
 import (
+	"Totem/blobstore"
+	"Totem/catalog"
+	"Totem/service"
+	"Totem/transport"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,6 +23,21 @@ type VSCOService struct {
 	Username string
 	Site     VSCOSite
 
+	account    *service.Account
+	httpClient *http.Client
+
+	// e.g. https://vsco.co/api/2.0
+	baseURL string
+
+	// Page through all of the media instead of stopping at archived media
+	fullScan bool
+
+	// Stores media files if set, shared with other accounts
+	blobs *blobstore.Store
+
+	// Kept in sync with everything stored if set
+	catalog *catalog.DB
+
 	// Path: ./Totem/{TrackingProfile.TargetName}
 	TargetDir string
 
@@ -31,149 +54,273 @@ type VSCOService struct {
 	CollectionDir string
 }
 
-var (
-	httpClient = http.DefaultClient
-)
-
 const (
+	// Name VSCOService is registered under
+	Platform = "vsco"
+
+	// The public VSCO API
+	DefaultBaseURL = "https://vsco.co/api/2.0"
+
+	// Account identifier keys
+	SiteIDKey = "site_id"
+	UserIDKey = "user_id"
+
 	concurrentMediaDownloads = 3 // Turning this too high will get you rate limited.
+
+	// Incremental scans stop paging after seeing this many archived media in a row
+	knownMediaBeforeStop = 30
+
+	// Incremental scans compare at least this many archived media for edits before stopping at media older than
+	// the newest archived media. Edits to older media are only noticed by full scans.
+	// Below the page size of 30, so a run with few new media still requests a single page.
+	comparedMediaBeforeStop = 20
+)
+
+func init() {
+	service.Register(Platform, func(account *service.Account, targetDir string, config service.Config) service.Service {
+		var options []Option
+		if config.HTTPClient != nil {
+			options = append(options, WithHTTPClient(config.HTTPClient))
+		}
+		if config.BaseURL != "" {
+			options = append(options, WithBaseURL(config.BaseURL))
+		}
+		options = append(options, WithFullScan(config.FullScan))
+		if config.Blobs != nil {
+			options = append(options, WithBlobStore(config.Blobs))
+		}
+		if config.Catalog != nil {
+			options = append(options, WithCatalog(config.Catalog))
+		}
+		return New(account, targetDir, options...)
+	})
+}
+
+var (
+	// Rate limits & retries requests unless WithHTTPClient replaces it
+	defaultHTTPClient = &http.Client{Transport: transport.New(nil, transport.DefaultConfig)}
 )
 
-func New(account *VSCOAccount, targetPath string) VSCOService {
+// Configures a VSCOService created by New
+type Option func(*VSCOService)
+
+// Sends all requests with client instead of a client using transport.DefaultConfig
+func WithHTTPClient(client *http.Client) Option {
+	return func(v *VSCOService) {
+		v.httpClient = client
+	}
+}
+
+// Sends API requests to baseURL instead of DefaultBaseURL, e.g. a mirror or a fake server
+func WithBaseURL(baseURL string) Option {
+	return func(v *VSCOService) {
+		v.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// Pages through all of the media on every run instead of stopping at media that is already archived
+func WithFullScan(fullScan bool) Option {
+	return func(v *VSCOService) {
+		v.fullScan = fullScan
+	}
+}
 
-	n := VSCOService{
+// Stores media files in blobs & links them into the media's directory, instead of writing them there
+func WithBlobStore(blobs *blobstore.Store) Option {
+	return func(v *VSCOService) {
+		v.blobs = blobs
+	}
+}
+
+// Records everything that is stored in db as well
+func WithCatalog(db *catalog.DB) Option {
+	return func(v *VSCOService) {
+		v.catalog = db
+	}
+}
+
+func New(account *service.Account, targetPath string, options ...Option) *VSCOService {
+	accountDir := service.AccountDir(targetPath, Platform, account.Username)
+	v := &VSCOService{
 		Username:      account.Username,
 		Site:          VSCOSite{},
+		account:       account,
+		httpClient:    defaultHTTPClient,
+		baseURL:       DefaultBaseURL,
 		TargetDir:     targetPath,
-		AccountDir:    targetPath + "/" + account.Username,
-		ProfileDir:    targetPath + "/" + account.Username + "/Profile",
-		GalleryDir:    targetPath + "/" + account.Username + "/Gallery",
-		CollectionDir: targetPath + "/" + account.Username + "/Collection",
+		AccountDir:    accountDir,
+		ProfileDir:    accountDir + "/Profile",
+		GalleryDir:    accountDir + "/Gallery",
+		CollectionDir: accountDir + "/Collection",
+	}
+	for _, option := range options {
+		option(v)
 	}
-	n.setSite(account)
+	return v
+}
 
-	if n.Site.Name != "" {
-		// Create profile directory
-		if _, err := os.Stat(n.ProfileDir); errors.Is(err, os.ErrNotExist) {
-			err := os.Mkdir(n.ProfileDir, os.ModePerm)
-			if err != nil {
-				panic(err)
-			}
-		}
+// Looks up the account's site, updates the account's status & creates the account's directories if it exists
+func (v *VSCOService) ResolveAccount(ctx context.Context) error {
+	err := v.setSite(ctx, v.account)
+	if err != nil {
+		return err
+	}
 
-		// Create gallery directory
-		if _, err := os.Stat(n.GalleryDir); errors.Is(err, os.ErrNotExist) {
-			err := os.Mkdir(n.GalleryDir, os.ModePerm)
-			if err != nil {
-				panic(err)
-			}
+	now := time.Now().Unix()
+	change, changed := v.account.UpdateStatus(v.Site.Name != "", now)
+	if changed {
+		err := v.appendHistory(service.Event{Kind: service.EventStatusChanged, Time: now, Changes: []string{change.From, change.To}})
+		if err != nil {
+			return err
 		}
+	}
+
+	// A reserved username was just taken, archive everything the new account has
+	if change.IsCreation() {
+		v.fullScan = true
+	}
 
-		// Create collection directory
-		if _, err := os.Stat(n.CollectionDir); errors.Is(err, os.ErrNotExist) {
-			err := os.Mkdir(n.CollectionDir, os.ModePerm)
+	if v.Site.Name != "" {
+		for _, dir := range []string{v.ProfileDir, v.GalleryDir, v.CollectionDir} {
+			err := mkdirIfNotExist(dir)
 			if err != nil {
-				panic(err)
+				return err
 			}
 		}
+		if err := v.writeSiteRecord(); err != nil {
+			return err
+		}
 	}
+	return v.syncAccount()
+}
 
-	return n
+func (v *VSCOService) FetchProfile(ctx context.Context) error {
+	return v.CheckProfileImage(ctx)
 }
 
-// Sets the VSCOService site & updates VSCOAccount information if necessary
-func (v *VSCOService) setSite(account *VSCOAccount) {
-	if account.SiteID != -1 {
-		request, err := http.NewRequest("GET", "https://vsco.co/api/2.0/sites/"+strconv.Itoa(account.SiteID), nil)
-		if err != nil {
-			panic(err)
-		}
-		setInfoRequestHeaders(request)
+// The bio is part of the site, so nothing is requested here
+func (v *VSCOService) FetchBio(ctx context.Context) error {
+	return v.CheckBio()
+}
 
-		response, err := httpClient.Do(request)
-		if err != nil {
-			panic(err)
-		}
+func (v *VSCOService) MediaSources() []service.MediaSource {
+	return []service.MediaSource{
+		{Name: "Gallery", Dir: v.GalleryDir},
+		{Name: "Collection", Dir: v.CollectionDir},
+	}
+}
+
+func (v *VSCOService) DownloadMedia(ctx context.Context, source service.MediaSource) error {
+	switch source.Name {
+	case "Gallery":
+		return v.CheckGalleryMedia(ctx)
+	case "Collection":
+		return v.CheckCollectionMedia(ctx)
+	}
+	return fmt.Errorf("vscoservice: unknown media source %q", source.Name)
+}
 
-		defer response.Body.Close()
-		body, err := io.ReadAll(response.Body)
+// How source's media is stored
+func (v *VSCOService) collectorFor(source service.MediaSource) (mediaCollector, error) {
+	switch source.Name {
+	case "Gallery":
+		return v.galleryCollector(), nil
+	case "Collection":
+		return v.collectionCollector(), nil
+	}
+	return mediaCollector{}, fmt.Errorf("vscoservice: unknown media source %q", source.Name)
+}
+
+// Sets the VSCOService site & updates the account's information if necessary.
+// v.Site is left empty if the account does not exist, its status records that.
+func (v *VSCOService) setSite(ctx context.Context, account *service.Account) error {
+	if account.ID(SiteIDKey) != "" {
+		request, err := http.NewRequestWithContext(ctx, "GET", v.baseURL+"/sites/"+account.ID(SiteIDKey), nil)
 		if err != nil {
-			panic(err)
+			return transportError("look up site", err)
 		}
+		setInfoRequestHeaders(request)
 
 		var VSCOSiteShim struct {
 			Site VSCOSite `json:"site"`
 		}
 
-		err = json.Unmarshal(body, &VSCOSiteShim)
-		if err != nil {
-			panic(err)
+		err = v.doJSONRequest("look up site "+account.ID(SiteIDKey), request, &VSCOSiteShim)
+		if err != nil && !errors.Is(err, service.ErrNotFound) {
+			return err
 		}
 
 		if VSCOSiteShim.Site.Name != "" {
 			v.Site = VSCOSiteShim.Site
-			account.UserID = v.Site.UserID
+			account.SetID(UserIDKey, strconv.Itoa(v.Site.UserID))
 
 			// Update directory name if Username has changed
 			if account.Username != v.Site.Name {
-				account.Username = v.Site.Name
-				v.Username = account.Username
-				err := os.Rename(v.AccountDir, v.TargetDir+"/"+v.Username)
+				err := os.Rename(v.AccountDir, service.AccountDir(v.TargetDir, Platform, v.Site.Name))
 				if err != nil {
-					panic(err)
+					return storageError("rename "+v.AccountDir, err)
 				}
 
+				now := time.Now().Unix()
+				account.RecordIdentityChange(service.FieldUsername, account.Username, v.Site.Name, now)
+				event := service.Event{Kind: service.EventIdentityChanged, Time: now, Changes: []string{service.FieldUsername, account.Username, v.Site.Name}}
+				oldRef := v.catalogRef()
+
+				account.Username = v.Site.Name
+				v.Username = account.Username
+
 				// Update all of the paths
-				v.AccountDir = v.TargetDir + "/" + v.Username
+				v.AccountDir = service.AccountDir(v.TargetDir, Platform, v.Username)
 				v.ProfileDir = v.AccountDir + "/Profile"
 				v.GalleryDir = v.AccountDir + "/Gallery"
 				v.CollectionDir = v.AccountDir + "/Collection"
+
+				// The catalog still has the account under its old username
+				if v.catalog != nil {
+					if err := v.catalog.RemoveAccount(oldRef); err != nil {
+						return storageError("update catalog", err)
+					}
+					if err := v.Reindex(); err != nil {
+						return err
+					}
+				}
+
+				err = v.appendHistory(event)
+				if err != nil {
+					return err
+				}
 			}
-		} else {
-			fmt.Println("User", account.Username, "has deleted their account.")
 		}
 	} else {
-		request, err := http.NewRequest("GET", "https://vsco.co/api/2.0/sites?subdomain="+account.Username, nil)
+		request, err := http.NewRequestWithContext(ctx, "GET", v.baseURL+"/sites?subdomain="+account.Username, nil)
 		if err != nil {
-			panic(err)
+			return transportError("look up "+account.Username, err)
 		}
 		setInfoRequestHeaders(request)
 
-		response, err := httpClient.Do(request)
-		if err != nil {
-			panic(err)
-		}
-
-		defer response.Body.Close()
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			panic(err)
-		}
-
 		var VSCOSiteShim struct {
 			Sites []VSCOSite `json:"sites"`
 		}
 
-		err = json.Unmarshal(body, &VSCOSiteShim)
-		if err != nil {
-			panic(err)
+		err = v.doJSONRequest("look up "+account.Username, request, &VSCOSiteShim)
+		if err != nil && !errors.Is(err, service.ErrNotFound) {
+			return err
 		}
 
 		if len(VSCOSiteShim.Sites) > 0 {
 			v.Site = VSCOSiteShim.Sites[0]
 
-			account.SiteID = v.Site.SiteID
-			account.UserID = v.Site.UserID
-		} else {
-			fmt.Println("User", account.Username, "does not currently exist.")
+			account.SetID(SiteIDKey, strconv.Itoa(v.Site.SiteID))
+			account.SetID(UserIDKey, strconv.Itoa(v.Site.UserID))
 		}
 	}
+	return nil
 }
 
 // Records changes to the user's bio
-func (v *VSCOService) CheckBio() {
+func (v *VSCOService) CheckBio() error {
 	if v.Site.Name == "" {
-		return
+		return nil
 	}
 
 	var bioRecords []BioRecord
@@ -181,16 +328,17 @@ func (v *VSCOService) CheckBio() {
 	bioRecordFile := v.ProfileDir + "/.BioRecord.json"
 
 	// Creates/Replaces .BioRecord.json file
-	update := func() {
+	update := func() error {
 		bytes, err := json.Marshal(bioRecords)
 		if err != nil {
-			panic(err)
+			return storageError("encode bio record", err)
 		}
 
 		err = os.WriteFile(bioRecordFile, bytes, os.ModePerm)
 		if err != nil {
-			panic(err)
+			return storageError("write bio record", err)
 		}
+		return v.syncBios(bioRecords)
 	}
 
 	// Initialize file if it does not exist, and return
@@ -198,267 +346,439 @@ func (v *VSCOService) CheckBio() {
 		bioRecords = []BioRecord{
 			{v.Site.Description, time.Now().Unix()},
 		}
-		update()
-		return
+		return update()
 	}
 
 	// Otherwise, read it & update it if it has changed
 	bytes, err := os.ReadFile(bioRecordFile)
 	if err != nil {
-		panic(err)
+		return storageError("read bio record", err)
 	}
 
 	err = json.Unmarshal(bytes, &bioRecords)
 	if err != nil {
-		panic(err)
+		return storageError("decode bio record", err)
 	}
 
 	bioRecordsLen := len(bioRecords)
 	if bioRecordsLen > 0 {
 		if v.Site.Description != bioRecords[bioRecordsLen-1].Description {
 			bioRecords = append(bioRecords, BioRecord{v.Site.Description, time.Now().Unix()})
-			update()
+			return update()
 		}
 	}
+	return nil
 }
 
-func (v *VSCOService) CheckProfileImage() {
+// Downloads the user's profile image if it changed & records when each profile image was seen
+func (v *VSCOService) CheckProfileImage(ctx context.Context) error {
 	if v.Site.Name == "" {
-		return
+		return nil
 	}
 
-	// Read contents of /Profile
-	entries := getEntriesDictForDir(v.ProfileDir)
-
-	// If the current profileImageID is not in /Profile, download it.
-	if !isInEntries(v.Site.ProfileImageID, entries) {
-		imageRequest, err := http.NewRequest("GET", "https://"+v.Site.ResponsiveURL, nil)
-		if err != nil {
-			panic(err)
-		}
-		setMediaRequestHeaders(imageRequest)
-
-		imageResponse, err := httpClient.Do(imageRequest)
-		if err != nil {
-			panic(err)
-		}
-		defer imageResponse.Body.Close()
+	records, err := v.readProfileImageRecords()
+	if err != nil {
+		return err
+	}
 
-		data, err := io.ReadAll(imageResponse.Body)
-		if err != nil {
-			panic(err)
-		}
+	now := time.Now().Unix()
+	imageFile := v.ProfileDir + "/" + v.Site.ProfileImageID + ".jpg"
+	_, statErr := os.Stat(imageFile)
 
-		err = os.WriteFile(v.ProfileDir+"/"+v.Site.ProfileImageID+".jpg", data, os.ModePerm)
-		if err != nil {
-			panic(err)
-		}
+	// Still the same profile image, only update when it was last seen
+	last := len(records) - 1
+	if last >= 0 && records[last].ImageID == v.Site.ProfileImageID && statErr == nil {
+		records[last].LastSeen = now
+		return v.writeProfileImageRecords(records)
 	}
-}
 
-// Collects all of the media on the user's profile /Gallery that has not been stored locally
-// Stored by upload date
-func (v *VSCOService) CheckGalleryMedia() {
-	if v.Site.Name == "" {
-		return
+	url := "https://" + v.Site.ResponsiveURL
+	imageRequest, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return transportError("download profile image", err)
 	}
+	setMediaRequestHeaders(imageRequest)
 
-	entries := getEntriesDictForDir(v.GalleryDir)
+	data, err := v.doMediaRequest("download profile image "+v.Site.ProfileImageID, imageRequest, false)
+	if err != nil {
+		return err
+	}
 
-	sink := DataSink{
-		semaphore: make(chan int, concurrentMediaDownloads),
-		data:      make(chan MediaData),
+	err = os.WriteFile(imageFile, data, os.ModePerm)
+	if err != nil {
+		return storageError("write profile image", err)
 	}
 
-	var page = 1
-	for {
-		media := v.galleryMediaRequest(page)
+	sum := sha256.Sum256(data)
+	record := ProfileImageRecord{v.Site.ProfileImageID, now, now, url, hex.EncodeToString(sum[:])}
 
-		if len(media) == 0 {
-			break
-		}
+	// The file went missing, so the record is still current
+	if last >= 0 && records[last].ImageID == v.Site.ProfileImageID {
+		record.FirstSeen = records[last].FirstSeen
+		records[last] = record
+		return v.writeProfileImageRecords(records)
+	}
 
-		for _, m := range media {
-			t := parseMilliTimestamp(m.UploadDate)
-			ts := t.Format("Mon, Jan 2, 15h04m05s, MST 2006")
-
-			if !isInEntries(ts, entries) {
-				sink.wg.Add(1)
-				go func(m VSCOMedia, ts string) {
-					sink.semaphore <- 1
-					defer func() {
-						<-sink.semaphore
-						entries[ts] = struct{}{}
-						sink.wg.Done()
-					}()
-
-					var mediaRequest *http.Request
-					var err error
-
-					if m.IsVideo {
-						mediaRequest, err = http.NewRequest("GET", "http://"+m.VideoURL, nil)
-						if err != nil {
-							panic(err)
-						}
-					} else {
-						mediaRequest, err = http.NewRequest("GET", "http://"+m.ResponsiveURL, nil)
-						if err != nil {
-							panic(err)
-						}
-					}
+	records = append(records, record)
+	err = v.writeProfileImageRecords(records)
+	if err != nil {
+		return err
+	}
 
-					setMediaRequestHeaders(mediaRequest)
+	if last >= 0 {
+		return v.appendHistory(service.Event{Kind: service.EventProfileImageChanged, Time: now, MediaID: v.Site.ProfileImageID})
+	}
+	return nil
+}
 
-					mediaResponse, err := httpClient.Do(mediaRequest)
-					if err != nil {
-						panic(err)
-					}
-					defer mediaResponse.Body.Close()
+// Reads .ProfileImageRecord.json. Profile images saved before it existed are recorded
+// as first & last seen when their file was written.
+func (v *VSCOService) readProfileImageRecords() ([]ProfileImageRecord, error) {
+	var records []ProfileImageRecord
 
-					data, err := io.ReadAll(mediaResponse.Body)
-					if err != nil {
-						panic(err)
-					}
-					sink.data <- MediaData{data, ts, m}
-				}(m, ts)
-			}
+	bytes, err := os.ReadFile(v.ProfileDir + "/.ProfileImageRecord.json")
+	if err == nil {
+		err = json.Unmarshal(bytes, &records)
+		if err != nil {
+			return nil, storageError("decode profile image record", err)
 		}
-		page++
+		return records, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, storageError("read profile image record", err)
 	}
 
-	go func() {
-		sink.wg.Wait()
-		close(sink.data)
-	}()
-
-	for md := range sink.data {
-		mediaDir := v.GalleryDir + "/" + md.Filename
-
-		os.Mkdir(mediaDir, os.ModePerm)
-
-		extension := ".jpg"
-		if md.VSCOMedia.IsVideo {
-			extension = ".mp4"
+	entries, err := os.ReadDir(v.ProfileDir)
+	if err != nil {
+		return nil, storageError("read "+v.ProfileDir, err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".jpg") {
+			continue
 		}
 
-		// Write image/video
-		err := os.WriteFile(mediaDir+"/"+md.VSCOMedia.ID+extension, md.Bytes, os.ModePerm)
+		info, err := e.Info()
 		if err != nil {
-			panic(err)
+			return nil, storageError("read "+e.Name(), err)
 		}
-
-		jsonBytes, err := json.Marshal(md.VSCOMedia)
+		data, err := os.ReadFile(v.ProfileDir + "/" + e.Name())
 		if err != nil {
-			panic(err)
+			return nil, storageError("read "+e.Name(), err)
 		}
 
-		// Write info file
-		err = os.WriteFile(mediaDir+"/"+"Info.json", jsonBytes, os.ModePerm)
-		if err != nil {
-			panic(err)
-		}
+		sum := sha256.Sum256(data)
+		written := info.ModTime().Unix()
+		records = append(records, ProfileImageRecord{strings.TrimSuffix(e.Name(), ".jpg"), written, written, "", hex.EncodeToString(sum[:])})
 	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].FirstSeen < records[j].FirstSeen
+	})
+	return records, nil
 }
 
-func (v *VSCOService) galleryMediaRequest(page int) []VSCOMedia {
-	request, err := http.NewRequest("GET", "https://vsco.co/api/2.0/medias?site_id="+strconv.Itoa(v.Site.SiteID)+"&size=30"+"&page="+strconv.Itoa(page), nil)
+func (v *VSCOService) writeProfileImageRecords(records []ProfileImageRecord) error {
+	bytes, err := json.Marshal(records)
 	if err != nil {
-		panic(err)
+		return storageError("encode profile image record", err)
 	}
-	setMediaRequestHeaders(request)
 
-	response, err := httpClient.Do(request)
+	err = os.WriteFile(v.ProfileDir+"/.ProfileImageRecord.json", bytes, os.ModePerm)
 	if err != nil {
-		panic(err)
+		return storageError("write profile image record", err)
+	}
+	return v.syncProfileImages(records)
+}
+
+// Prints out when each of the user's profile images was seen
+func (v *VSCOService) PrintProfileImages(ctx context.Context) error {
+	if _, err := os.Stat(v.ProfileDir); errors.Is(err, os.ErrNotExist) {
+		fmt.Println("This user has no profile image record")
+		return nil
+	}
+
+	records, err := v.readProfileImageRecords()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Println("This user has no profile image record")
+		return nil
+	}
+
+	fmt.Println("User:", v.Username)
+
+	for _, r := range records {
+		first := time.Unix(r.FirstSeen, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+		last := time.Unix(r.LastSeen, 0).Format("Mon, Jan 2, 15h04m05s, MST 2006")
+
+		fmt.Println(first, "-", last, "|", v.ProfileDir+"/"+r.ImageID+".jpg", "| sha256:", r.SHA256)
+	}
+	return nil
+}
+
+// Collects all of the media on the user's profile /Gallery that has not been stored locally
+// Stored by upload date
+func (v *VSCOService) CheckGalleryMedia(ctx context.Context) error {
+	if v.Site.Name == "" {
+		return nil
 	}
 
-	defer response.Body.Close()
-	body, err := io.ReadAll(response.Body)
+	return v.collectMedia(ctx, v.galleryCollector())
+}
+
+func (v *VSCOService) galleryCollector() mediaCollector {
+	return mediaCollector{
+		dir:     v.GalleryDir,
+		request: v.galleryMediaRequest,
+		mediaTime: func(m VSCOMedia) time.Time {
+			return parseMilliTimestamp(m.UploadDate)
+		},
+		newestFirst:     true,
+		recordDeletions: true,
+		source:          "Gallery",
+	}
+}
+
+func (v *VSCOService) galleryMediaRequest(ctx context.Context, page int) ([]VSCOMedia, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", v.baseURL+"/medias?site_id="+strconv.Itoa(v.Site.SiteID)+"&size=30"+"&page="+strconv.Itoa(page), nil)
 	if err != nil {
-		panic(err)
+		return nil, transportError("list gallery", err)
 	}
+	setMediaRequestHeaders(request)
 
 	var VSCOMediaShim struct {
 		Media []VSCOMedia `json:"media"`
 	}
 
-	err = json.Unmarshal(body, &VSCOMediaShim)
+	err = v.doJSONRequest("list gallery page "+strconv.Itoa(page), request, &VSCOMediaShim)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return VSCOMediaShim.Media
+	return VSCOMediaShim.Media, nil
 }
 
-// Collects all of the media on the user's profile /Gallery that has not been stored locally
+// Collects all of the media on the user's profile /Collection that has not been stored locally
 // Stored by collected date
-func (v *VSCOService) CheckCollectionMedia() {
+func (v *VSCOService) CheckCollectionMedia(ctx context.Context) error {
 	if v.Site.Name == "" {
-		return
+		return nil
 	}
 
-	entries := getEntriesDictForDir(v.CollectionDir)
+	return v.collectMedia(ctx, v.collectionCollector())
+}
+
+func (v *VSCOService) collectionCollector() mediaCollector {
+	return mediaCollector{
+		dir:     v.CollectionDir,
+		source:  "Collection",
+		request: v.collectionMediaRequest,
+		mediaTime: func(m VSCOMedia) time.Time {
+			return parseMilliTimestamp(m.CollectedDate)
+		},
+	}
+}
+
+func (v *VSCOService) collectionMediaRequest(ctx context.Context, page int) ([]VSCOMedia, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", v.baseURL+"/collections/"+v.Site.SiteCollectionID+"/medias?size=30"+"&page="+strconv.Itoa(page), nil)
+	if err != nil {
+		return nil, transportError("list collection", err)
+	}
+	setMediaRequestHeaders(request)
+
+	var VSCOMediaShim struct {
+		Media []VSCOMedia `json:"medias"`
+	}
+
+	err = v.doJSONRequest("list collection page "+strconv.Itoa(page), request, &VSCOMediaShim)
+	if err != nil {
+		return nil, err
+	}
+
+	return VSCOMediaShim.Media, nil
+}
+
+// How collectMedia pages through & stores one of the account's media sources
+type mediaCollector struct {
+	dir     string
+	request func(ctx context.Context, page int) ([]VSCOMedia, error)
+
+	// Each media is stored in a directory named after this time & its ID, see mediaDirName
+	mediaTime func(VSCOMedia) time.Time
+
+	// Set if pages are sorted newest mediaTime first,
+	// so paging can stop at media older than the newest archived media
+	newestFirst bool
+
+	// Set to record archived media that a complete scan did not see as deleted in the account's history
+	recordDeletions bool
+	source          string
+}
+
+// Pages through c.request & downloads all of the media that is not in c.dir.
+// Unless v.fullScan is set, paging stops once knownMediaBeforeStop archived media are seen in a row,
+// or at media older than the newest archived media once comparedMediaBeforeStop archived media were compared for edits.
+// A scan that pages through all of the media records archived media it did not see as deleted, if c.recordDeletions is set.
+// Archived media that was edited gets its Info.json replaced, keeping the old one in Revisions.json.
+// Media that fails to download is skipped & recorded in c.dir/.FailedDownloads.json to be retried on the next run,
+// the first failure is returned once the rest are stored.
+// Once ctx is cancelled no new pages or downloads are started, but downloads that were started finish & are written.
+func (v *VSCOService) collectMedia(ctx context.Context, c mediaCollector) error {
+	entries, err := getEntriesDictForDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	index, err := readMediaIndex(c.dir, c.mediaTime)
+	if err != nil {
+		return err
+	}
+
+	failedFile := c.dir + "/.FailedDownloads.json"
+	previousFailures, err := readFailedDownloads(failedFile)
+	if err != nil {
+		return err
+	}
 
 	sink := DataSink{
 		semaphore: make(chan int, concurrentMediaDownloads),
 		data:      make(chan MediaData),
 	}
 
+	filename := func(m VSCOMedia) string {
+		return mediaDirName(c.mediaTime(m), m.ID)
+	}
+
+	// Media that is indexed under an older directory name is still archived
+	archived := func(m VSCOMedia) bool {
+		if im, ok := index.Media[m.ID]; ok && isInEntries(im.Filename, entries) {
+			return true
+		}
+		return isInEntries(filename(m), entries)
+	}
+
+	// Media is only added to entries once it is written, queued stops it from being downloaded twice
+	queued := make(map[string]struct{})
+	download := func(m VSCOMedia) {
+		if archived(m) || isInEntries(m.ID, queued) {
+			return
+		}
+		queued[m.ID] = struct{}{}
+		ts := filename(m)
+
+		sink.wg.Add(1)
+		go func(m VSCOMedia, ts string) {
+			sink.semaphore <- 1
+			defer func() {
+				<-sink.semaphore
+				sink.wg.Done()
+			}()
+
+			if err := ctx.Err(); err != nil {
+				sink.data <- MediaData{nil, ts, m, err}
+				return
+			}
+
+			// Downloads that were started are finished, only a second interrupt, which exits, stops them.
+			// They are not retried once the run is interrupted though, so a rate limited one does not hold up the exit.
+			data, err := v.downloadMedia(transport.WithRetryContext(detachedContext{ctx}, ctx), m)
+			sink.data <- MediaData{data, ts, m, err}
+		}(m, ts)
+	}
+
+	// Incremental scans need a complete scan before them, otherwise older media could be missed
+	incremental := !v.fullScan && index.Complete
+	newest := index.newest()
+	index.Complete = false
+
+	now := time.Now().Unix()
+	var events []service.Event
+	seen := make(map[string]struct{})
+	reachedEnd := false
+
+	// Media that was stored, edited, deleted or restored, updated in the catalog at the end
+	changed := make(map[string]struct{})
+
+	var pageErr, revisionErr error
+	var known, compared int
 	var page = 1
-	for {
-		media := v.collectionMediaRequest(page)
+paging:
+	for ctx.Err() == nil {
+		media, err := c.request(ctx, page)
+		if err != nil {
+			pageErr = err
+			break
+		}
 
 		if len(media) == 0 {
+			index.Complete = true
+			reachedEnd = true
 			break
 		}
 
 		for _, m := range media {
-			t := parseMilliTimestamp(m.UploadDate + m.CollectedDate)
-			ts := t.Format("Mon, Jan 2, 15h04m05s, MST 2006")
-
-			if !isInEntries(ts, entries) {
-				sink.wg.Add(1)
-				go func(m VSCOMedia, ts string) {
-					sink.semaphore <- 1
-					defer func() {
-						<-sink.semaphore
-						entries[ts] = struct{}{}
-						sink.wg.Done()
-					}()
-
-					var mediaRequest *http.Request
-					var err error
-
-					if m.IsVideo {
-						mediaRequest, err = http.NewRequest("GET", "http://"+m.VideoURL, nil)
-						if err != nil {
-							panic(err)
-						}
-					} else {
-						mediaRequest, err = http.NewRequest("GET", "http://"+m.ResponsiveURL, nil)
-						if err != nil {
-							panic(err)
+			seen[m.ID] = struct{}{}
+
+			im, indexed := index.Media[m.ID]
+			if indexed {
+				if im.Deleted != 0 {
+					im.Deleted = 0
+					changed[m.ID] = struct{}{}
+					events = append(events, service.Event{Kind: service.EventMediaRestored, Time: now, Source: c.source, MediaID: m.ID})
+				}
+				im.LastSeen = now
+				im.Uploaded, im.Collected = m.UploadDate, m.CollectedDate
+
+				// Keep a revision if the caption, tags, location etc. were edited
+				if fingerprint := mediaFingerprint(m); fingerprint != im.Fingerprint {
+					fields, err := recordRevision(c.dir+"/"+im.Filename, m, now)
+					if err != nil && revisionErr == nil {
+						revisionErr = err
+					} else if err == nil {
+						if len(fields) > 0 {
+							events = append(events, service.Event{Kind: service.EventMediaEdited, Time: now, Source: c.source, MediaID: m.ID, Changes: fields})
 						}
+						im.Fingerprint = fingerprint
+						changed[m.ID] = struct{}{}
 					}
+				}
+				index.Media[m.ID] = im
+			}
 
-					setMediaRequestHeaders(mediaRequest)
+			if indexed || archived(m) {
+				known++
+			} else {
+				known = 0
+			}
+			if indexed {
+				compared++
+			}
 
-					mediaResponse, err := httpClient.Do(mediaRequest)
-					if err != nil {
-						panic(err)
-					}
-					defer mediaResponse.Body.Close()
+			older := c.newestFirst && c.mediaTime(m).UnixNano()/int64(time.Millisecond) < newest
+			if incremental && (known >= knownMediaBeforeStop || (older && compared >= comparedMediaBeforeStop)) {
+				index.Complete = true
+				break paging
+			}
 
-					data, err := io.ReadAll(mediaResponse.Body)
-					if err != nil {
-						panic(err)
-					}
-					sink.data <- MediaData{data, ts, m}
-				}(m, ts)
+			download(m)
+		}
+		page++
+	}
 
+	// Everything on VSCO was seen, so archived media that was not has been deleted
+	if reachedEnd && c.recordDeletions {
+		for id, im := range index.Media {
+			if _, ok := seen[id]; !ok && im.Deleted == 0 {
+				im.Deleted = now
+				index.Media[id] = im
+				changed[id] = struct{}{}
+				events = append(events, service.Event{Kind: service.EventMediaDeleted, Time: now, Source: c.source, MediaID: id, LastSeen: im.LastSeen})
 			}
 		}
-		page++
+	}
+
+	// Retry the media that failed last run & was not seen while paging
+	for _, f := range previousFailures {
+		download(f.Media)
 	}
 
 	go func() {
@@ -466,87 +786,214 @@ func (v *VSCOService) CheckCollectionMedia() {
 		close(sink.data)
 	}()
 
+	var failures []FailedDownload
+	var failed, skipped int
+	var firstErr error
 	for md := range sink.data {
-		mediaDir := v.CollectionDir + "/" + md.Filename
-
-		os.Mkdir(mediaDir, os.ModePerm)
+		err := md.Err
+		var sum string
+		if err == nil {
+			sum, err = v.writeMedia(c.dir+"/"+md.Filename, md)
+		}
+		if err == nil {
+			entries[md.Filename] = struct{}{}
+			im := indexMedia(md.Filename, md.VSCOMedia, c.mediaTime)
+			im.LastSeen = now
+			im.SHA256 = sum
+			index.Media[md.VSCOMedia.ID] = im
+			changed[md.VSCOMedia.ID] = struct{}{}
+			continue
+		}
+
+		// Media that was never started because the run was interrupted is retried next run,
+		// without counting as an attempt
+		previous, retried := previousFailures[md.VSCOMedia.ID]
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			if !retried {
+				previous = FailedDownload{Media: md.VSCOMedia, Error: err.Error(), LastAttempt: time.Now().Unix()}
+			}
+			failures = append(failures, previous)
+			skipped++
+			continue
+		}
 
-		extension := ".jpg"
-		if md.VSCOMedia.IsVideo {
-			extension = ".mp4"
+		failures = append(failures, FailedDownload{md.VSCOMedia, err.Error(), previous.Attempts + 1, time.Now().Unix()})
+		failed++
+		if firstErr == nil {
+			firstErr = err
 		}
+	}
 
-		// Write image/video
-		err := os.WriteFile(mediaDir+"/"+md.VSCOMedia.ID+extension, md.Bytes, os.ModePerm)
-		if err != nil {
-			panic(err)
+	// Failures that were neither retried nor seen again stay recorded
+	for id, f := range previousFailures {
+		if !isInEntries(id, queued) && !archived(f.Media) {
+			failures = append(failures, f)
 		}
+	}
 
-		jsonBytes, err := json.Marshal(md.VSCOMedia)
-		if err != nil {
-			panic(err)
+	if err := writeFailedDownloads(failedFile, failures); err != nil {
+		return err
+	}
+	if err := writeMediaIndex(c.dir, index); err != nil {
+		return err
+	}
+	if err := v.appendHistory(events...); err != nil {
+		return err
+	}
+	if err := v.syncMedia(c, index, changed); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		if skipped > 0 {
+			return fmt.Errorf("%d media left undone: %w", skipped, err)
 		}
+		return err
+	}
+	if pageErr != nil {
+		return pageErr
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d media failed & will be retried next run: %w", failed, firstErr)
+	}
+	return revisionErr
+}
 
-		// Write info file
-		err = os.WriteFile(mediaDir+"/"+"Info.json", jsonBytes, os.ModePerm)
-		if err != nil {
-			panic(err)
+// Reads the failed downloads recorded in file, by media ID
+func readFailedDownloads(file string) (map[string]FailedDownload, error) {
+	failures := make(map[string]FailedDownload)
+
+	bytes, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return failures, nil
+	} else if err != nil {
+		return nil, storageError("read failed downloads", err)
+	}
+
+	var records []FailedDownload
+	err = json.Unmarshal(bytes, &records)
+	if err != nil {
+		return nil, storageError("decode failed downloads", err)
+	}
+
+	for _, f := range records {
+		failures[f.Media.ID] = f
+	}
+	return failures, nil
+}
+
+// Replaces file with failures, or removes it if there are none
+func writeFailedDownloads(file string, failures []FailedDownload) error {
+	if len(failures) == 0 {
+		err := os.Remove(file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return storageError("remove failed downloads", err)
 		}
+		return nil
+	}
+
+	bytes, err := json.Marshal(failures)
+	if err != nil {
+		return storageError("encode failed downloads", err)
 	}
+
+	err = os.WriteFile(file, bytes, os.ModePerm)
+	if err != nil {
+		return storageError("write failed downloads", err)
+	}
+	return nil
 }
 
-func (v *VSCOService) collectionMediaRequest(page int) []VSCOMedia {
-	request, err := http.NewRequest("GET", "https://vsco.co/api/2.0/collections/"+v.Site.SiteCollectionID+"/medias?size=30"+"&page="+strconv.Itoa(page), nil)
+// Downloads the image or video of m
+func (v *VSCOService) downloadMedia(ctx context.Context, m VSCOMedia) ([]byte, error) {
+	url := "http://" + m.ResponsiveURL
+	if m.IsVideo {
+		url = "http://" + m.VideoURL
+	}
+
+	mediaRequest, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		panic(err)
+		return nil, transportError("download media "+m.ID, err)
 	}
-	setMediaRequestHeaders(request)
+	setMediaRequestHeaders(mediaRequest)
 
-	response, err := httpClient.Do(request)
+	return v.doMediaRequest("download media "+m.ID, mediaRequest, m.IsVideo)
+}
+
+// Writes the media & its Info.json into mediaDir, the media through the blob store if there is one.
+// Returns the media's SHA-256.
+// If anything fails mediaDir is removed, so the media is not mistaken for archived.
+func (v *VSCOService) writeMedia(mediaDir string, md MediaData) (sum string, err error) {
+	err = os.Mkdir(mediaDir, os.ModePerm)
 	if err != nil {
-		panic(err)
+		return "", storageError("create "+mediaDir, err)
 	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(mediaDir)
+		}
+	}()
 
-	defer response.Body.Close()
-	body, err := io.ReadAll(response.Body)
+	// Write image/video
+	mediaFile := mediaDir + "/" + mediaFileName(md.VSCOMedia)
+	if v.blobs != nil {
+		sum, err = v.blobs.Put(mediaFile, md.Bytes)
+	} else {
+		sum, err = blobstore.Sum(md.Bytes), os.WriteFile(mediaFile, md.Bytes, os.ModePerm)
+	}
 	if err != nil {
-		panic(err)
+		return "", storageError("write media "+md.VSCOMedia.ID, err)
+	}
+	if err := writeMediaMetadata(mediaDir, md.VSCOMedia.ID, md.Bytes); err != nil {
+		return "", err
 	}
 
-	var VSCOMediaShim struct {
-		Media []VSCOMedia `json:"medias"`
+	jsonBytes, err := json.Marshal(md.VSCOMedia)
+	if err != nil {
+		return "", storageError("encode media info "+md.VSCOMedia.ID, err)
 	}
 
-	err = json.Unmarshal(body, &VSCOMediaShim)
+	// Write info file
+	err = os.WriteFile(mediaDir+"/"+"Info.json", jsonBytes, os.ModePerm)
 	if err != nil {
-		panic(err)
+		return "", storageError("write media info "+md.VSCOMedia.ID, err)
 	}
+	return sum, nil
+}
 
-	return VSCOMediaShim.Media
+// The name of m's image or video file
+func mediaFileName(m VSCOMedia) string {
+	if m.IsVideo {
+		return m.ID + ".mp4"
+	}
+	return m.ID + ".jpg"
 }
 
 // Prints out the user's bio records & optionally checks if there is a new one.
-func (v *VSCOService) PrintBio(withUpdate bool) {
+func (v *VSCOService) PrintBio(ctx context.Context, withUpdate bool) error {
 	bioRecordFile := v.ProfileDir + "/.BioRecord.json"
 	var bioRecords []BioRecord
 
 	if withUpdate {
-		v.CheckBio()
+		err := v.FetchBio(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
 	if _, err := os.Stat(bioRecordFile); errors.Is(err, os.ErrNotExist) {
 		fmt.Println("This user has no bio record")
-		return
+		return nil
 	}
 
 	bytes, err := os.ReadFile(bioRecordFile)
 	if err != nil {
-		panic(err)
+		return storageError("read bio record", err)
 	}
 
 	err = json.Unmarshal(bytes, &bioRecords)
 	if err != nil {
-		panic(err)
+		return storageError("decode bio record", err)
 	}
 
 	fmt.Println("User:", v.Username)
@@ -557,4 +1004,5 @@ func (v *VSCOService) PrintBio(withUpdate bool) {
 
 		fmt.Println(ts, "|", r.Description)
 	}
+	return nil
 }